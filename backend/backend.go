@@ -2,8 +2,10 @@ package backend
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net"
@@ -13,6 +15,8 @@ import (
 	"time"
 
 	"github.com/chengshiwen/influx-tool/util"
+	"github.com/influxdata/influxdb1-client/models"
+	jsoniter "github.com/json-iterator/go"
 	gzip "github.com/klauspost/pgzip"
 )
 
@@ -65,7 +69,16 @@ func NewQueryRequest(method, db, q, epoch string) *http.Request {
 	return &http.Request{Method: method, Form: form, Header: header}
 }
 
-func (be *Backend) Query(req *http.Request) (body []byte, err error) {
+func NewChunkedQueryRequest(method, db, q, epoch string, chunkSize int) *http.Request {
+	req := NewQueryRequest(method, db, q, epoch)
+	req.Form.Set("chunked", "true")
+	if chunkSize > 0 {
+		req.Form.Set("chunk_size", fmt.Sprintf("%d", chunkSize))
+	}
+	return req
+}
+
+func (be *Backend) roundTrip(req *http.Request) (resp *http.Response, body io.ReadCloser, err error) {
 	if len(req.Form) == 0 {
 		req.Form = url.Values{}
 	}
@@ -83,24 +96,44 @@ func (be *Backend) Query(req *http.Request) (body []byte, err error) {
 	}
 
 	q := strings.TrimSpace(req.FormValue("q"))
-	resp, err := be.transport.RoundTrip(req)
+	resp, err = be.transport.RoundTrip(req)
 	if err != nil {
 		log.Printf("query error: %s, the query is %s", err, q)
 		return
 	}
-	defer resp.Body.Close()
 
-	respBody := resp.Body
+	body = resp.Body
 	if resp.Header.Get("Content-Encoding") == "gzip" {
 		b, err := gzip.NewReader(resp.Body)
 		if err != nil {
+			resp.Body.Close()
 			log.Printf("unable to decode gzip body")
-			return nil, err
+			return nil, nil, err
 		}
-		defer b.Close()
-		respBody = b
+		body = &gzipBody{Reader: b, body: resp.Body}
+	}
+	return
+}
+
+// gzipBody closes both the gzip reader and the underlying response body.
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (gb *gzipBody) Close() error {
+	gb.Reader.Close()
+	return gb.body.Close()
+}
+
+func (be *Backend) Query(req *http.Request) (body []byte, err error) {
+	resp, respBody, err := be.roundTrip(req)
+	if err != nil {
+		return
 	}
+	defer respBody.Close()
 
+	q := strings.TrimSpace(req.FormValue("q"))
 	body, err = ioutil.ReadAll(respBody)
 	if err != nil {
 		log.Printf("read body error: %s, the query is %s", err, q)
@@ -113,17 +146,80 @@ func (be *Backend) Query(req *http.Request) (body []byte, err error) {
 	return
 }
 
+// QueryChunk sends a chunked query and calls fn with the series of every chunk as soon as
+// it arrives, so the whole result never has to be held in memory.
+func (be *Backend) QueryChunk(req *http.Request, fn func(series models.Rows) error) (err error) {
+	resp, respBody, err := be.roundTrip(req)
+	if err != nil {
+		return
+	}
+	defer respBody.Close()
+
+	q := strings.TrimSpace(req.FormValue("q"))
+	dec := jsoniter.NewDecoder(respBody)
+	dec.UseNumber()
+	for dec.More() {
+		rsp := &Response{}
+		if err = dec.Decode(rsp); err != nil {
+			log.Printf("read body error: %s, the query is %s", err, q)
+			return
+		}
+		if rsp.Err != "" {
+			return errors.New(rsp.Err)
+		}
+		for _, r := range rsp.Results {
+			if r.Err != "" {
+				return errors.New(r.Err)
+			}
+			if len(r.Series) > 0 {
+				if err = fn(r.Series); err != nil {
+					return
+				}
+			}
+		}
+	}
+	if resp.StatusCode >= 400 {
+		err = fmt.Errorf("query failed with status %d, the query is %s", resp.StatusCode, q)
+	}
+	return
+}
+
 func (be *Backend) QueryIQL(method, db, q, epoch string) ([]byte, error) {
 	return be.Query(NewQueryRequest(method, db, q, epoch))
 }
 
-func (be *Backend) GetSeriesValues(db, q string) []string {
-	var values []string
+func (be *Backend) QueryIQLChunk(method, db, q, epoch string, chunkSize int, fn func(series models.Rows) error) error {
+	return be.QueryChunk(NewChunkedQueryRequest(method, db, q, epoch, chunkSize), fn)
+}
+
+// QuerySeries sends a query and returns the series of its first result, or the error of the query.
+func (be *Backend) QuerySeries(db, q string) (models.Rows, error) {
 	p, err := be.Query(NewQueryRequest("GET", db, q, ""))
 	if err != nil {
-		return values
+		return nil, err
+	}
+	rsp, err := ResponseFromResponseBytes(p)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.Err != "" {
+		return nil, errors.New(rsp.Err)
+	}
+	if len(rsp.Results) == 0 {
+		return nil, nil
+	}
+	if rsp.Results[0].Err != "" {
+		return nil, errors.New(rsp.Results[0].Err)
+	}
+	return rsp.Results[0].Series, nil
+}
+
+func (be *Backend) GetSeriesValues(db, q string) ([]string, error) {
+	var values []string
+	series, err := be.QuerySeries(db, q)
+	if err != nil {
+		return nil, err
 	}
-	series, _ := SeriesFromResponseBytes(p)
 	for _, s := range series {
 		for _, v := range s.Values {
 			if s.Name == "databases" && v[0].(string) == "_internal" {
@@ -132,30 +228,76 @@ func (be *Backend) GetSeriesValues(db, q string) []string {
 			values = append(values, v[0].(string))
 		}
 	}
-	return values
+	return values, nil
+}
+
+func (be *Backend) GetDatabases() ([]string, error) {
+	return be.GetSeriesValues("", "show databases")
 }
 
-func (be *Backend) GetMeasurements(db string) []string {
+type RetentionPolicy struct {
+	Name               string
+	Duration           time.Duration
+	ShardGroupDuration time.Duration
+	Replication        int
+	Default            bool
+}
+
+func (be *Backend) GetRetentionPolicies(db string) ([]*RetentionPolicy, error) {
+	var rps []*RetentionPolicy
+	q := fmt.Sprintf("show retention policies on \"%s\"", util.EscapeIdentifier(db))
+	series, err := be.QuerySeries(db, q)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range series {
+		idx := make(map[string]int, len(s.Columns))
+		for i, c := range s.Columns {
+			idx[c] = i
+		}
+		for _, v := range s.Values {
+			rp := &RetentionPolicy{Name: v[idx["name"]].(string)}
+			rp.Duration, _ = time.ParseDuration(v[idx["duration"]].(string))
+			rp.ShardGroupDuration, _ = time.ParseDuration(v[idx["shardGroupDuration"]].(string))
+			if n, ok := v[idx["replicaN"]].(json.Number); ok {
+				replication, _ := n.Int64()
+				rp.Replication = int(replication)
+			}
+			rp.Default, _ = v[idx["default"]].(bool)
+			rps = append(rps, rp)
+		}
+	}
+	return rps, nil
+}
+
+func (be *Backend) GetMeasurements(db string) ([]string, error) {
 	return be.GetSeriesValues(db, "show measurements")
 }
 
-func (be *Backend) GetTagKeys(db, meas string) []string {
-	return be.GetSeriesValues(db, fmt.Sprintf("show tag keys from \"%s\"", util.EscapeIdentifier(meas)))
+func (be *Backend) GetTagKeys(db, rp, meas string) ([]string, error) {
+	return be.GetSeriesValues(db, fmt.Sprintf("show tag keys from %s", Source(rp, meas)))
 }
 
-func (be *Backend) GetFieldKeys(db, meas string) map[string][]string {
+func (be *Backend) GetFieldKeys(db, rp, meas string) (map[string][]string, error) {
 	fieldKeys := make(map[string][]string)
-	q := fmt.Sprintf("show field keys from \"%s\"", util.EscapeIdentifier(meas))
-	p, err := be.Query(NewQueryRequest("GET", db, q, ""))
+	q := fmt.Sprintf("show field keys from %s", Source(rp, meas))
+	series, err := be.QuerySeries(db, q)
 	if err != nil {
-		return fieldKeys
+		return nil, err
 	}
-	series, _ := SeriesFromResponseBytes(p)
 	for _, s := range series {
 		for _, v := range s.Values {
 			fk := v[0].(string)
 			fieldKeys[fk] = append(fieldKeys[fk], v[1].(string))
 		}
 	}
-	return fieldKeys
+	return fieldKeys, nil
+}
+
+// Source returns the quoted measurement source of a query, qualified by the retention policy if not empty.
+func Source(rp, meas string) string {
+	if rp == "" {
+		return fmt.Sprintf("\"%s\"", util.EscapeIdentifier(meas))
+	}
+	return fmt.Sprintf("\"%s\".\"%s\"", util.EscapeIdentifier(rp), util.EscapeIdentifier(meas))
 }
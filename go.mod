@@ -1,14 +1,16 @@
 module github.com/chengshiwen/influx-tool
 
-go 1.14
+go 1.16
 
 require (
+	github.com/apache/arrow/go/arrow v0.0.0-20200730104253-651201b0f516
 	github.com/gogo/protobuf v1.3.2 // indirect
 	github.com/influxdata/influxdb1-client v0.0.0-20200827194710-b269163b24ab
 	github.com/influxdata/influxql v1.1.0
 	github.com/json-iterator/go v1.1.11
-	github.com/klauspost/compress v1.11.13 // indirect
 	github.com/klauspost/pgzip v1.2.5
 	github.com/mitchellh/gox v1.0.1 // indirect
 	github.com/panjf2000/ants/v2 v2.4.5
+	github.com/xitongsys/parquet-go v1.6.2
+	modernc.org/sqlite v1.17.3
 )
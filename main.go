@@ -5,17 +5,19 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math"
-	"os/exec"
+	"os"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/chengshiwen/influx-tool/backend"
 	"github.com/chengshiwen/influx-tool/tool"
 	"github.com/chengshiwen/influx-tool/util"
+	"github.com/influxdata/influxql"
 	"github.com/panjf2000/ants/v2"
 )
 
@@ -25,27 +27,57 @@ var (
 	BuildTime = "unknown"
 )
 
+// FailedFile is the name of the file in dir listing the measurements failed to export, which is removed
+// when all measurements are exported, so it must not be the name of any file exported into dir.
+const FailedFile = "failed.tsv"
+
 var (
-	Host          string
-	Port          int
-	Database      string
-	Measurements  string
-	Range         string
-	Start         string
-	End           string
-	Format        string
-	Username      string
-	Password      string
-	Ssl           bool
-	Dir           string
-	Worker        int
-	Merge         bool
-	BooleanFields string
-	FloatFields   string
-	IntegerFields string
-	VersionFlag   bool
-	Pool          *ants.Pool
-	Wg            sync.WaitGroup
+	Host             string
+	Port             int
+	Database         string
+	RetentionPolicy  string
+	Measurements     string
+	Range            string
+	Start            string
+	End              string
+	Where            string
+	Format           string
+	Precision        string
+	TimeFormat       string
+	TimeZone         string
+	Username         string
+	Password         string
+	Ssl              bool
+	Dir              string
+	Window           string
+	DownsampleFlag   string
+	Aggregates       string
+	Fill             string
+	DownsampleMeas   string
+	Worker           int
+	Merge            bool
+	Resume           bool
+	Incremental      bool
+	MeasurementsFile string
+	StateFile        string
+	Compress         bool
+	MaxFileSize      string
+	MaxFileLines     int64
+	SQLBatch         int
+	NonNumeric       string
+	GraphiteTemplate string
+	Hypertable       bool
+	BooleanFields    string
+	FloatFields      string
+	IntegerFields    string
+	IncludeTags      string
+	ExcludeTags      string
+	IncludeFields    string
+	ExcludeFields    string
+	MappingFile      string
+	VersionFlag      bool
+	Pool             *ants.Pool
+	Wg               sync.WaitGroup
 )
 
 func castFields() map[string][]string {
@@ -64,24 +96,197 @@ func castFields() map[string][]string {
 	return map[string][]string{"boolean": booleanFields, "float": floatFields, "integer": integerFields}
 }
 
+func isMulti(names string) bool {
+	return strings.Contains(names, ",") || util.HasWildcard(names)
+}
+
+func selectDatabases(be *backend.Backend) ([]string, error) {
+	if util.HasWildcard(Database) {
+		databases, err := be.GetDatabases()
+		if err != nil {
+			return nil, err
+		}
+		return util.WildcardFilter(util.String2Array(Database), databases), nil
+	}
+	return util.String2Array(Database), nil
+}
+
+func selectRetentionPolicies(be *backend.Backend, db string) ([]*backend.RetentionPolicy, error) {
+	allRps, err := be.GetRetentionPolicies(db)
+	if err != nil {
+		return nil, err
+	}
+	rps := make([]*backend.RetentionPolicy, 0)
+	if isMulti(RetentionPolicy) {
+		names := make([]string, 0, len(allRps))
+		for _, rp := range allRps {
+			names = append(names, rp.Name)
+		}
+		for _, name := range util.WildcardFilter(util.String2Array(RetentionPolicy), names) {
+			for _, rp := range allRps {
+				if rp.Name == name {
+					rps = append(rps, rp)
+				}
+			}
+		}
+	} else {
+		for _, rp := range allRps {
+			if rp.Name == RetentionPolicy || (RetentionPolicy == "" && rp.Default) {
+				rps = append(rps, rp)
+			}
+		}
+	}
+	return rps, nil
+}
+
+func selectMeasurements(be *backend.Backend, db string) ([]string, error) {
+	if Measurements == "" {
+		return be.GetMeasurements(db)
+	} else if util.HasWildcard(Measurements) {
+		measurements, err := be.GetMeasurements(db)
+		if err != nil {
+			return nil, err
+		}
+		return util.WildcardFilter(util.String2Array(Measurements), measurements), nil
+	}
+	return util.String2Array(Measurements), nil
+}
+
+// failure is a measurement failed to export, or all measurements of a database failed to list if meas is empty.
+type failure struct {
+	db   string
+	rp   string
+	meas string
+	err  error
+}
+
+func (f failure) String() string {
+	if f.meas == "" {
+		return fmt.Sprintf("%s: %s", f.db, f.err)
+	}
+	return fmt.Sprintf("%s.%s.%s: %s", f.db, f.rp, f.meas, f.err)
+}
+
+// writeFailedFile writes the failed measurements into the file, one per line as
+// 'database<TAB>retention policy<TAB>measurement', which can be passed back by -measurements-file.
+// A database failed to list its retention policies or measurements is written as 'database<TAB>*<TAB>*'.
+func writeFailedFile(path string, failures []failure) error {
+	lines := make([]string, 0, len(failures))
+	for _, f := range failures {
+		rp, meas := f.rp, f.meas
+		if meas == "" {
+			rp, meas = "*", "*"
+		}
+		lines = append(lines, strings.Join([]string{f.db, rp, meas}, "\t")+"\n")
+	}
+	return ioutil.WriteFile(path, []byte(strings.Join(lines, "")), 0644)
+}
+
+// loadMeasurementsFile loads the measurements to export from the file, one per line as 'measurement' for all databases
+// and retention policies, or 'database<TAB>retention policy<TAB>measurement' like the failed file, where the retention
+// policy and measurement may be '*' for all of them.
+func loadMeasurementsFile(path string) (func(db, rp, meas string) bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	names, triples := util.NewSet(), util.NewSet()
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		switch parts := strings.Split(line, "\t"); len(parts) {
+		case 1:
+			names.Add(line)
+		case 3:
+			triples.Add(line)
+		default:
+			return nil, fmt.Errorf("invalid line: %s", line)
+		}
+	}
+	if len(names) == 0 && len(triples) == 0 {
+		// a file selecting nothing would export nothing and succeed
+		return nil, fmt.Errorf("no measurement in %s", path)
+	}
+	return func(db, rp, meas string) bool {
+		return names[meas] || triples[strings.Join([]string{db, rp, meas}, "\t")] ||
+			triples[strings.Join([]string{db, rp, "*"}, "\t")] || triples[strings.Join([]string{db, "*", "*"}, "\t")]
+	}, nil
+}
+
+// verifyManifest verifies the export files against the manifest in dir, and returns the exit code.
+func verifyManifest(args []string) int {
+	fs := flag.NewFlagSet("verify-manifest", flag.ExitOnError)
+	fs.StringVar(&Dir, "dir", "export", "directory of the manifest to verify")
+	fs.Parse(args)
+	manifest, err := tool.LoadManifest(Dir)
+	if err != nil {
+		fmt.Printf("invalid manifest: %s\n", err)
+		return 1
+	}
+	fmt.Printf("manifest written by version %s (git commit %s) at %s\n", manifest.Version, manifest.GitCommit, manifest.Time.Format(time.RFC3339))
+	failed := 0
+	for _, entry := range manifest.Files {
+		if err := tool.VerifyFile(Dir, entry); err != nil {
+			fmt.Printf("%s: %s\n", entry.Path, err)
+			failed++
+		}
+	}
+	fmt.Printf("%d/%d files verified\n", len(manifest.Files)-failed, len(manifest.Files))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "verify-manifest" {
+		os.Exit(verifyManifest(os.Args[2:]))
+	}
 	flag.StringVar(&Host, "host", "127.0.0.1", "host to connect to")
 	flag.IntVar(&Port, "port", 8086, "port to connect to")
-	flag.StringVar(&Database, "database", "", "database to connect to the server")
+	flag.StringVar(&Database, "database", "", "database to connect to the server\nmultiple databases split by ',' or wildcard '*' and '?' supported, each exported into its own subdirectory")
+	flag.StringVar(&RetentionPolicy, "retention-policy", "", "retention policy to export, the default retention policy if empty\nmultiple retention policies split by ',' or wildcard '*' and '?' supported, each exported into its own subdirectory")
+	flag.StringVar(&MeasurementsFile, "measurements-file", "", "file of measurements to export, one per line as 'measurement' or 'database<TAB>retention policy<TAB>measurement'\nlike the failed file written into dir when any measurement failed, optional")
 	flag.StringVar(&Measurements, "measurements", "", "measurements split by ',' while return all measurements if empty\nwildcard '*' and '?' supported")
 	flag.StringVar(&Range, "range", "", "measurements range to export, as 'start,end', started from 1, included end\nignored when -measurements not empty")
 	flag.StringVar(&Start, "start", "", "the start unix time to export (second precision), optional")
 	flag.StringVar(&End, "end", "", "the end unix time to export (second precision), optional")
-	flag.StringVar(&Format, "format", "line", "the output format to export, valid values are line or csv")
+	flag.StringVar(&Where, "where", "", "condition on tags and fields to filter exported data, such as \"region='eu' AND env!='dev'\", optional")
+	flag.StringVar(&Format, "format", "line", "the output format to export, valid values are line, csv, jsonl, annotated-csv, parquet, arrow, sql, sqlite, prometheus or graphite\nannotated-csv is the annotated csv of InfluxDB 2.x to import by influx write --format csv, always with rfc3339 timestamps in UTC\nparquet and arrow (ipc file, also known as feather v2) are written into a file per measurement, without merge, compress or file limits\nsql is the dump of CREATE TABLE and INSERT statements for PostgreSQL or TimescaleDB\nsqlite is written into a database file export.db with a table per measurement, without merge, compress or file limits\nprometheus is the text exposition format with timestamps in milliseconds, which VictoriaMetrics imports\ngraphite is the plaintext protocol of numeric fields with timestamps in seconds")
+	flag.StringVar(&Precision, "precision", "ns", "the timestamp precision of exported data, valid values are ns, us, ms, s, m or h")
+	flag.StringVar(&TimeFormat, "time-format", "epoch", "the timestamp format of csv and jsonl, valid values are epoch or rfc3339")
+	flag.StringVar(&TimeZone, "time-zone", "UTC", "the time zone of rfc3339 timestamps, such as UTC, Local or Asia/Shanghai")
 	flag.StringVar(&Username, "username", "", "username to connect to the server")
 	flag.StringVar(&Password, "password", "", "password to connect to the server")
 	flag.BoolVar(&Ssl, "ssl", false, "use https for requests")
 	flag.StringVar(&Dir, "dir", "export", "directory to export")
+	flag.StringVar(&Window, "window", "", "time window to split each measurement export into, such as 1h or 1d, optional\nslices of one measurement are exported concurrently by workers")
+	flag.StringVar(&DownsampleFlag, "downsample", "", "interval to downsample data into with group by time(), such as 5m or 1h, optional\nonly supported when -format is line or jsonl")
+	flag.StringVar(&Aggregates, "aggregates", "float=mean,integer=mean,string=last,boolean=last", "aggregate functions to downsample, split by ','\nas 'type=function', 'field=function' or 'measurement:field=function'")
+	flag.StringVar(&Fill, "fill", "none", "fill policy to downsample, valid values are none, null, previous, linear or a number")
+	flag.StringVar(&DownsampleMeas, "downsample-measurement", "{measurement}", "measurement name to write downsampled data, where {measurement} is replaced by the measurement name")
 	flag.IntVar(&Worker, "worker", 1, "number of concurrent workers to export")
-	flag.BoolVar(&Merge, "merge", false, "merge and export into one file, ignored when -format is not line")
+	flag.BoolVar(&Merge, "merge", false, "merge and export into one file")
+	flag.BoolVar(&Incremental, "incremental", false, "export only the data after the watermark of each measurement in the state file, which is advanced when exported successfully")
+	flag.StringVar(&StateFile, "state-file", "", "state file to keep the watermarks of incremental export (default \"state.json\" in the parent of dir)")
+	flag.BoolVar(&Resume, "resume", false, "resume from the checkpoint file in dir, skipping measurements exported completely by a previous run")
+	flag.BoolVar(&Compress, "compress", false, "compress export files with gzip, which can be imported by influx -import -compressed")
+	flag.StringVar(&MaxFileSize, "max-file-size", "", "max size of each export file before compression, such as 512MB or 10GB\nrotated into numbered parts like cpu.0001.txt when exceeded, unlimited if empty")
+	flag.Int64Var(&MaxFileLines, "max-file-lines", 0, "max lines of data of each export file\nrotated into numbered parts like cpu.0001.txt when exceeded, unlimited if 0")
+	flag.IntVar(&SQLBatch, "sql-batch", 1000, "rows per INSERT statement when -format is sql")
+	flag.BoolVar(&Hypertable, "hypertable", false, "create the tables as TimescaleDB hypertables with create_hypertable when -format is sql")
+	flag.StringVar(&NonNumeric, "non-numeric", "skip", "policy of string and boolean fields when -format is prometheus, valid values are skip or map\nmap writes booleans as 1 or 0, and strings as 1 with the string in the label value")
+	flag.StringVar(&GraphiteTemplate, "graphite-template", "measurement.field", "template of metric paths when -format is graphite, with nodes split by '.'\nmeasurement, field and tag:<key> are replaced by the measurement, field key and tag value, such as measurement.tag:host.field")
 	flag.StringVar(&BooleanFields, "boolean-fields", "", "fields required to cast to boolean from string, split by ','")
 	flag.StringVar(&FloatFields, "float-fields", "", "fields required to cast to float from string, split by ','")
 	flag.StringVar(&IntegerFields, "integer-fields", "", "fields required to cast to integer from string, split by ','")
+	flag.StringVar(&IncludeTags, "include-tags", "", "tag keys to export, split by ',', all tag keys if empty\nas 'key' for all measurements or 'measurement:key', wildcard '*' and '?' supported")
+	flag.StringVar(&ExcludeTags, "exclude-tags", "", "tag keys not to export, split by ','\nas 'key' for all measurements or 'measurement:key', wildcard '*' and '?' supported")
+	flag.StringVar(&IncludeFields, "include-fields", "", "field keys to export, split by ',', all field keys if empty\nas 'key' for all measurements or 'measurement:key', wildcard '*' and '?' supported")
+	flag.StringVar(&ExcludeFields, "exclude-fields", "", "field keys not to export, split by ','\nas 'key' for all measurements or 'measurement:key', wildcard '*' and '?' supported")
+	flag.StringVar(&MappingFile, "mapping", "", "mapping file in json to rename databases, retention policies, measurements, tag keys and field keys, optional")
 	flag.BoolVar(&VersionFlag, "version", false, "display the version and exit")
 	flag.Parse()
 	if VersionFlag {
@@ -95,19 +300,123 @@ func main() {
 
 	if Database == "" {
 		fmt.Println("database required")
-		return
+		os.Exit(1)
 	}
 	if err := util.MakeDir(Dir); err != nil {
 		fmt.Println("invalid dir")
-		return
+		os.Exit(1)
 	}
 	if Worker <= 0 || Worker > 4*runtime.NumCPU() {
 		fmt.Println("invalid worker, not more than 4*cpus")
-		return
+		os.Exit(1)
 	}
-	if Format != "line" && Format != "csv" {
+	if !tool.ValidFormat(Format) {
 		fmt.Println("invalid format")
-		return
+		os.Exit(1)
+	}
+
+	if tool.PrecisionUnit(Precision) == 0 {
+		fmt.Println("invalid precision")
+		os.Exit(1)
+	}
+	var location *time.Location
+	if TimeFormat == "rfc3339" {
+		if Format != "csv" && Format != "jsonl" {
+			fmt.Println("rfc3339 time format only supported when format is csv or jsonl")
+			os.Exit(1)
+		}
+		loc, err := time.LoadLocation(TimeZone)
+		if err != nil {
+			fmt.Println("invalid time zone")
+			os.Exit(1)
+		}
+		location = loc
+	} else if TimeFormat != "epoch" {
+		fmt.Println("invalid time format")
+		os.Exit(1)
+	}
+
+	limit := tool.FileLimit{MaxLines: MaxFileLines}
+	if MaxFileSize != "" {
+		size, err := util.ParseSize(MaxFileSize)
+		if err != nil || size <= 0 {
+			fmt.Println("invalid max file size")
+			os.Exit(1)
+		}
+		limit.MaxSize = size
+	}
+	if MaxFileLines < 0 {
+		fmt.Println("invalid max file lines")
+		os.Exit(1)
+	}
+	if !tool.NonNumericPolicies[NonNumeric] {
+		fmt.Println("invalid non numeric policy")
+		os.Exit(1)
+	}
+	graphiteTemplate, err := tool.ParseGraphiteTemplate(GraphiteTemplate)
+	if err != nil {
+		fmt.Printf("invalid graphite template: %s\n", err)
+		os.Exit(1)
+	}
+	if SQLBatch <= 0 {
+		fmt.Println("invalid sql batch")
+		os.Exit(1)
+	}
+	if !tool.TextFormat(Format) && (Merge || Compress || limit.Enabled()) {
+		fmt.Printf("merge, compress and file limits not supported when format is %s\n", Format)
+		os.Exit(1)
+	}
+
+	where := ""
+	if Where != "" {
+		w, err := tool.ParseWhere(Where)
+		if err != nil {
+			fmt.Printf("invalid where: %s\n", err)
+			os.Exit(1)
+		}
+		where = w
+	}
+
+	var mapping *tool.Mapping
+	if MappingFile != "" {
+		m, err := tool.LoadMapping(MappingFile)
+		if err != nil {
+			fmt.Printf("invalid mapping: %s\n", err)
+			os.Exit(1)
+		}
+		mapping = m
+	}
+
+	var window time.Duration
+	if Window != "" {
+		d, err := influxql.ParseDuration(Window)
+		if err != nil || d <= 0 {
+			fmt.Println("invalid window")
+			os.Exit(1)
+		}
+		window = d
+	}
+
+	var downsample *tool.Downsample
+	if DownsampleFlag != "" {
+		interval, err := influxql.ParseDuration(DownsampleFlag)
+		if err != nil || interval <= 0 {
+			fmt.Println("invalid downsample")
+			os.Exit(1)
+		}
+		if Format != "line" && Format != "jsonl" {
+			fmt.Println("downsample only supported when format is line or jsonl")
+			os.Exit(1)
+		}
+		if window%interval != 0 {
+			fmt.Println("invalid window, not a multiple of downsample")
+			os.Exit(1)
+		}
+		downsample, err = tool.NewDownsample(interval, Aggregates, Fill, DownsampleMeas)
+		if err != nil {
+			fmt.Printf("invalid downsample: %s\n", err)
+			os.Exit(1)
+		}
 	}
 
 	rangeStart := 1
@@ -117,7 +426,7 @@ func main() {
 		matches := pattern.FindStringSubmatch(Range)
 		if len(matches) != 3 {
 			fmt.Println("invalid range")
-			return
+			os.Exit(1)
 		}
 		if matches[1] != "" {
 			rangeStart, _ = strconv.Atoi(matches[1])
@@ -127,7 +436,7 @@ func main() {
 		}
 		if rangeStart == 0 || rangeStart > rangeEnd {
 			fmt.Println("invalid range")
-			return
+			os.Exit(1)
 		}
 	}
 
@@ -143,65 +452,301 @@ func main() {
 		EndTime = 9223372036
 	}
 
-	backend := backend.NewBackend(Host, Port, Username, Password, Ssl)
-	measurements := make([]string, 0)
-	if Measurements == "" {
-		measurements = backend.GetMeasurements(Database)
-	} else {
-		if strings.Contains(Measurements, "*") || strings.Contains(Measurements, "?") {
-			patterns := util.String2Array(Measurements)
-			allMeases := backend.GetMeasurements(Database)
-			for _, meas := range allMeases {
-				for _, pat := range patterns {
-					if strings.Contains(pat, "*") || strings.Contains(pat, "?") {
-						if util.WildcardMatch(pat, meas) {
-							measurements = append(measurements, meas)
-						}
-					} else if meas == pat {
-						measurements = append(measurements, meas)
-					}
-				}
+	var selected func(db, rp, meas string) bool
+	if MeasurementsFile != "" {
+		f, err := loadMeasurementsFile(MeasurementsFile)
+		if err != nil {
+			fmt.Printf("invalid measurements file: %s\n", err)
+			os.Exit(1)
+		}
+		selected = f
+	}
+
+	checkpoint, err := tool.OpenCheckpoint(Dir, Resume)
+	if err != nil {
+		fmt.Printf("invalid checkpoint: %s\n", err)
+		os.Exit(1)
+	}
+
+	var state *tool.State
+	if Incremental {
+		if StateFile == "" {
+			// shared by the runs into the directories next to dir, such as backup/20201001 and backup/20201002
+			dir, err := filepath.Abs(Dir)
+			if err != nil {
+				fmt.Printf("invalid dir: %s\n", err)
+				os.Exit(1)
 			}
-		} else {
-			measurements = util.String2Array(Measurements)
+			StateFile = filepath.Join(filepath.Dir(dir), tool.StateFile)
+		}
+		state, err = tool.LoadState(StateFile)
+		if err != nil {
+			fmt.Printf("invalid state file: %s\n", err)
+			os.Exit(1)
 		}
 	}
 
-	castFields := castFields()
+	be := backend.NewBackend(Host, Port, Username, Password, Ssl)
+	databases, err := selectDatabases(be)
+	if err != nil {
+		fmt.Printf("show databases error: %s\n", err)
+		os.Exit(1)
+	}
+	if len(databases) == 0 {
+		fmt.Println("database not found")
+		os.Exit(1)
+	}
+	multiDb, multiRp := isMulti(Database), isMulti(RetentionPolicy)
 
-	cnt := 0
+	exports := make([]*tool.MeasurementExport, 0)
+	// a sqlite database file is written into each directory of exports
+	sqliteDBs, sqliteDirs := make([]*tool.SQLiteDB, 0), make(map[string]*tool.SQLiteDB)
 	Pool, _ = ants.NewPool(Worker)
 	defer Pool.Release()
-	for i, measurement := range measurements {
-		_i, _measurement := i, measurement
-		if Range != "" && (_i < rangeStart-1 || _i >= rangeEnd) {
+	castFields := castFields()
+	projection := tool.NewProjection(IncludeTags, ExcludeTags, IncludeFields, ExcludeFields)
+	total := 0
+	failures := make([]failure, 0)
+	var failuresMu sync.Mutex
+	ddls := make([]string, 0, len(databases))
+	for _, db := range databases {
+		rps, err := selectRetentionPolicies(be, db)
+		if err != nil {
+			// measurements of the databases before are being prepared and may fail concurrently
+			failuresMu.Lock()
+			failures = append(failures, failure{db: db, err: fmt.Errorf("show retention policies error: %s", err)})
+			failuresMu.Unlock()
 			continue
 		}
-		cnt++
-		Wg.Add(1)
-		Pool.Submit(func() {
-			defer Wg.Done()
-			if Format == "csv" {
-				tool.ExportCsv(backend, Database, _measurement, StartTime, EndTime, Dir, castFields)
-			} else {
-				tool.Export(backend, Database, _measurement, StartTime, EndTime, Dir, castFields, Merge)
+		if len(rps) == 0 {
+			fmt.Printf("retention policy not found on %s\n", db)
+			if !multiDb {
+				os.Exit(1)
+			}
+			continue
+		}
+		measurements, err := selectMeasurements(be, db)
+		if err != nil {
+			failuresMu.Lock()
+			failures = append(failures, failure{db: db, err: fmt.Errorf("show measurements error: %s", err)})
+			failuresMu.Unlock()
+			continue
+		}
+		total += len(measurements) * len(rps)
+		ddls = append(ddls, tool.GetDDL(mapping.TargetDatabase(db), mapping.TargetRetentionPolicies(rps)))
+		for _, rp := range rps {
+			opts := &tool.ExportOptions{
+				Database:         db,
+				RetentionPolicy:  rp,
+				Start:            StartTime,
+				End:              EndTime,
+				Where:            where,
+				Projection:       projection,
+				Mapping:          mapping,
+				Downsample:       downsample,
+				Window:           int64(window),
+				Dir:              Dir,
+				Format:           Format,
+				Precision:        Precision,
+				Location:         location,
+				Merge:            Merge,
+				Compress:         Compress,
+				Limit:            limit,
+				SQLBatch:         SQLBatch,
+				Hypertable:       Hypertable,
+				NonNumeric:       NonNumeric,
+				GraphiteTemplate: graphiteTemplate,
+				CastFields:       castFields,
+			}
+			// each database and retention policy is exported into its own subdirectory
+			prefix := ""
+			if multiDb {
+				opts.Dir = filepath.Join(opts.Dir, db)
+				prefix += db + "."
+			}
+			if multiRp {
+				opts.Dir = filepath.Join(opts.Dir, rp.Name)
+				prefix += rp.Name + "."
+			}
+			if err := util.MakeDir(opts.Dir); err != nil {
+				fmt.Println("invalid dir")
+				os.Exit(1)
+			}
+			if Format == "sqlite" {
+				if sqliteDirs[opts.Dir] == nil {
+					sd, err := tool.OpenSQLiteDB(opts.Dir)
+					if err != nil {
+						fmt.Printf("open sqlite error: %s\n", err)
+						os.Exit(1)
+					}
+					sqliteDBs = append(sqliteDBs, sd)
+					sqliteDirs[opts.Dir] = sd
+				}
+				opts.SQLite = sqliteDirs[opts.Dir]
+			}
+			fingerprint := opts.Fingerprint()
+			for i, measurement := range measurements {
+				_i, _measurement, _len := i, measurement, len(measurements)
+				if Range != "" && (_i < rangeStart-1 || _i >= rangeEnd) {
+					continue
+				}
+				if selected != nil && !selected(db, rp.Name, _measurement) {
+					continue
+				}
+				me := tool.NewMeasurementExport(be, opts, _measurement)
+				exports = append(exports, me)
+				if entry := checkpoint.Done(db, rp.Name, _measurement, StartTime, EndTime, fingerprint); entry != nil {
+					me.Resume(entry.Files)
+					if state != nil && entry.Watermark != nil {
+						state.Advance(db, rp.Name, _measurement, *entry.Watermark)
+					}
+					fmt.Printf("%d/%d: %s%s resumed\n", _i+1, _len, prefix, _measurement)
+					continue
+				}
+				_db, _rp := db, rp.Name
+				if state != nil {
+					if watermark, ok := state.Watermark(_db, _rp, _measurement); ok {
+						me.Since(watermark)
+					}
+				}
+				me.OnDone = func(err error) {
+					if err == nil {
+						entry := &tool.CheckpointEntry{
+							Database:        _db,
+							RetentionPolicy: _rp,
+							Measurement:     _measurement,
+							Start:           StartTime,
+							End:             EndTime,
+							Fingerprint:     fingerprint,
+							Files:           me.Files(),
+						}
+						if watermark, ok := me.Watermark(); ok {
+							entry.Watermark = &watermark
+							if state != nil {
+								state.Advance(_db, _rp, _measurement, watermark)
+							}
+						}
+						if err = checkpoint.Complete(entry); err != nil {
+							fmt.Printf("checkpoint error: %s\n", err)
+						}
+					}
+					if err != nil {
+						failuresMu.Lock()
+						failures = append(failures, failure{db: _db, rp: _rp, meas: _measurement, err: err})
+						failuresMu.Unlock()
+					}
+					fmt.Printf("%d/%d: %s%s processed\n", _i+1, _len, prefix, _measurement)
+				}
+				Wg.Add(1)
+				Pool.Submit(func() {
+					defer Wg.Done()
+					me.Prepare()
+				})
 			}
-			fmt.Printf("%d/%d: %s processed\n", _i+1, len(measurements), _measurement)
-		})
+		}
+	}
+	Wg.Wait()
+	for _, me := range exports {
+		_me := me
+		for j := 0; j < _me.Slices(); j++ {
+			_j := j
+			Wg.Add(1)
+			Pool.Submit(func() {
+				defer Wg.Done()
+				_me.ExportSlice(_j)
+			})
+		}
 	}
 	Wg.Wait()
-	fmt.Printf("%d/%d measurements export done\n", cnt, len(measurements))
-	if Format == "line" && Merge {
-		ioutil.WriteFile(filepath.Join(Dir, "merge.tmp"), []byte(tool.GetDMLHeader(Database)+"\n"), 0644)
-		err := exec.Command("sh", "-c", fmt.Sprintf("cat %s >> %s", filepath.Join(Dir, "*.txt"), filepath.Join(Dir, "merge.tmp"))).Run()
+	fmt.Printf("%d/%d measurements export done\n", len(exports), total)
+	for _, sd := range sqliteDBs {
+		if err := sd.Close(); err != nil {
+			fmt.Printf("close sqlite error: %s\n", err)
+			checkpoint.Close()
+			os.Exit(1)
+		}
+	}
+	failedFile := filepath.Join(Dir, FailedFile)
+	if len(failures) > 0 {
+		fmt.Printf("%d failures:\n", len(failures))
+		for _, f := range failures {
+			fmt.Printf("  %s\n", f)
+		}
+		if err := writeFailedFile(failedFile, failures); err != nil {
+			fmt.Printf("write failed file error: %s\n", err)
+		} else {
+			fmt.Printf("failed measurements written into %s, run again with -resume or -measurements-file %s to export them\n", failedFile, failedFile)
+		}
+		// the merged file is not written, neither are the watermarks of merged measurements
+		if state != nil && !Merge {
+			saveState(state)
+		}
+		checkpoint.Close()
+		os.Exit(1)
+	}
+	os.Remove(failedFile)
+	manifest := &tool.Manifest{Version: Version, GitCommit: GitCommit, Time: time.Now()}
+	if Merge {
+		// merged in the order of export, which is deterministic
+		paths := make([]string, 0, len(exports))
+		for _, me := range exports {
+			paths = append(paths, me.Paths()...)
+		}
+		opts := &tool.ExportOptions{Dir: Dir, Format: Format, Precision: Precision, Compress: Compress, Limit: limit}
+		stats, err := tool.MergeFiles(paths, tool.GetMergeHeader(ddls, Precision), opts)
+		if err == nil {
+			err = manifest.Add(Dir, tool.ManifestEntry{Format: Format, Precision: Precision, Compressed: Compress}, stats)
+		}
 		if err != nil {
 			fmt.Printf("merge error: %s\n", err)
-			return
+			checkpoint.Close()
+			os.Exit(1)
 		}
-		err = exec.Command("sh", "-c", fmt.Sprintf("cd %s && rm -f *.txt && mv merge.tmp merge.txt", Dir)).Run()
-		if err != nil {
-			fmt.Printf("rename error: %s\n", err)
-			return
+	} else if Format == "sqlite" {
+		for _, sd := range sqliteDBs {
+			// the tables of measurements are described by an entry of the database file
+			entry, stats := tool.ManifestEntry{Format: Format, Precision: Precision}, make([]*tool.FileStat, 0)
+			for _, me := range exports {
+				for _, stat := range me.Files() {
+					if stat.Path == sd.Path() {
+						entry.Database, entry.RetentionPolicy = me.ManifestEntry().Database, me.ManifestEntry().RetentionPolicy
+						stats = append(stats, stat)
+					}
+				}
+			}
+			stat, err := tool.SQLiteStat(sd.Path(), stats)
+			if err == nil {
+				err = manifest.Add(Dir, entry, []*tool.FileStat{stat})
+			}
+			if err != nil {
+				fmt.Printf("manifest error: %s\n", err)
+				checkpoint.Close()
+				os.Exit(1)
+			}
 		}
+	} else {
+		for _, me := range exports {
+			if err := manifest.Add(Dir, me.ManifestEntry(), me.Files()); err != nil {
+				fmt.Printf("manifest error: %s\n", err)
+				checkpoint.Close()
+				os.Exit(1)
+			}
+		}
+	}
+	if err := tool.WriteManifest(Dir, manifest); err != nil {
+		fmt.Printf("manifest error: %s\n", err)
+		checkpoint.Close()
+		os.Exit(1)
+	}
+	if state != nil {
+		saveState(state)
+	}
+	// nothing is left to resume
+	checkpoint.Remove()
+}
+
+func saveState(state *tool.State) {
+	if err := state.Save(); err != nil {
+		fmt.Printf("save state error: %s\n", err)
 	}
 }
@@ -0,0 +1,247 @@
+package tool
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb1-client/models"
+)
+
+// annotatedTypes lists the field types in the order their tables are written, with the datatypes of annotated csv.
+var annotatedTypes = []struct{ vtype, datatype string }{
+	{"float", "double"},
+	{"integer", "long"},
+	{"boolean", "boolean"},
+	{"string", "string"},
+}
+
+// annotatedWriter writes the annotated csv of InfluxDB 2.x, as a row of _measurement, _field, _value and _time
+// with the tags for each field value, which influx write --format csv reads.
+// The _value column of a table has a single datatype, so the rows are spooled by field type
+// and written when closed as a table for each type, every part starting with the annotations of its table.
+type annotatedWriter struct {
+	*seriesColumns
+	f    *rotateFile
+	base string
+	meas string
+	unit int64
+
+	spools      map[string]*annotatedSpool
+	annotations string
+}
+
+// annotatedSpool keeps the rows of a field type until closed, each as a csv record led by its timestamp.
+type annotatedSpool struct {
+	path string
+	f    *os.File
+	bw   *bufio.Writer
+	csvw *csv.Writer
+}
+
+func (aw *annotatedWriter) WriteSeries(row *models.Row) error {
+	if aw.spools == nil {
+		aw.spools = make(map[string]*annotatedSpool)
+		aw.f.header = func() string { return aw.annotations }
+	}
+	return aw.decode(row, func(p *seriesPoint) error {
+		tags := make(map[string]string, len(p.tags))
+		for _, tag := range p.tags {
+			tags[tag[0]] = tag[1]
+		}
+		for _, field := range p.fields {
+			record := []string{strconv.FormatInt(p.time, 10), formatValue(field.value), aw.fieldNames[field.key], aw.meas}
+			for _, k := range aw.tagKeys {
+				record = append(record, tags[k])
+			}
+			sp, err := aw.spool(field.vtype)
+			if err != nil {
+				return err
+			}
+			if err = sp.csvw.Write(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (aw *annotatedWriter) spool(vtype string) (*annotatedSpool, error) {
+	if sp, ok := aw.spools[vtype]; ok {
+		return sp, nil
+	}
+	sp := &annotatedSpool{path: fmt.Sprintf("%s.%s.spool", aw.base, vtype)}
+	f, err := os.Create(sp.path)
+	if err != nil {
+		return nil, err
+	}
+	sp.f, sp.bw = f, bufio.NewWriterSize(f, WriterBufferSize)
+	sp.csvw = csv.NewWriter(sp.bw)
+	aw.spools[vtype] = sp
+	return sp, nil
+}
+
+// Close writes the spooled rows table by table, and removes the spools.
+func (aw *annotatedWriter) Close() (err error) {
+	var buf bytes.Buffer
+	csvw := csv.NewWriter(&buf)
+	table := 0
+	for _, at := range annotatedTypes {
+		sp, ok := aw.spools[at.vtype]
+		if !ok {
+			continue
+		}
+		sp.csvw.Flush()
+		if ferr := sp.bw.Flush(); err == nil {
+			err = ferr
+		}
+		sp.f.Close()
+		if err == nil {
+			err = aw.writeTable(sp.path, at.datatype, table, &buf, csvw)
+		}
+		os.Remove(sp.path)
+		table++
+	}
+	if cerr := aw.f.Close(); err == nil {
+		err = cerr
+	}
+	return
+}
+
+func (aw *annotatedWriter) writeTable(path, datatype string, table int, buf *bytes.Buffer, csvw *csv.Writer) error {
+	format := func(record []string) (string, error) {
+		buf.Reset()
+		csvw.Write(record)
+		csvw.Flush()
+		return buf.String(), csvw.Error()
+	}
+	groups := []string{"#group", "false", "false", "false", "false", "true", "true"}
+	datatypes := []string{"#datatype", "string", "long", "dateTime:RFC3339", datatype, "string", "string"}
+	defaults := []string{"#default", "_result", "", "", "", "", ""}
+	names := []string{"", "result", "table", "_time", "_value", "_field", "_measurement"}
+	for _, k := range aw.tagKeys {
+		groups = append(groups, "true")
+		datatypes = append(datatypes, "string")
+		defaults = append(defaults, "")
+		names = append(names, aw.tagNames[k])
+	}
+	lines := make([]string, 0, 4)
+	for _, record := range [][]string{groups, datatypes, defaults, names} {
+		line, err := format(record)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, line)
+	}
+	aw.annotations = strings.TrimSuffix(strings.Join(lines, ""), "\n")
+	// tables are separated by an empty line, unless the table starts a part led by the annotations as the header
+	lead := "\n" + aw.annotations + "\n"
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cr := csv.NewReader(bufio.NewReaderSize(f, WriterBufferSize))
+	cr.ReuseRecord = true
+	id := strconv.Itoa(table)
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		t, err := strconv.ParseInt(record[0], 10, 64)
+		if err != nil {
+			return err
+		}
+		ts := time.Unix(0, t*aw.unit).UTC().Format(time.RFC3339Nano)
+		line, err := format(append([]string{"", "", id, ts}, record[1:]...))
+		if err != nil {
+			return err
+		}
+		if err = aw.f.WritePointAfter(lead, line, t); err != nil {
+			return err
+		}
+		lead = ""
+	}
+}
+
+func (aw *annotatedWriter) Stats() []*FileStat {
+	return aw.f.Stats()
+}
+
+// mergeAnnotatedFiles concatenates the tables of annotated csv files separated by an empty line,
+// where every part of the merged file starts with the annotations of the table it continues with.
+func mergeAnnotatedFiles(paths []string, base, ext string, opts *ExportOptions) (*rotateFile, error) {
+	var annotations []string
+	rf, err := openMergeFile(base, ext, opts, func() string {
+		return strings.TrimSuffix(strings.Join(annotations, ""), "\n")
+	})
+	if err != nil {
+		return rf, err
+	}
+	var buf bytes.Buffer
+	csvw := csv.NewWriter(&buf)
+	format := func(record []string) (string, error) {
+		buf.Reset()
+		csvw.Write(record)
+		csvw.Flush()
+		return buf.String(), csvw.Error()
+	}
+	// the annotations of a table are written along with its first row, separated from the table before by an empty line
+	var lead string
+	written := false
+	for _, path := range paths {
+		err := readFile(path, opts.Compress, func(r io.Reader) error {
+			cr := newCsvReader(r)
+			// tables of different tags differ in columns
+			cr.FieldsPerRecord = -1
+			for {
+				record, err := cr.Read()
+				if err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+				line, err := format(record)
+				if err != nil {
+					return err
+				}
+				switch {
+				case record[0] == "#group":
+					annotations = []string{line}
+				case strings.HasPrefix(record[0], "#"):
+					annotations = append(annotations, line)
+				case len(record) > 1 && record[1] == "result":
+					annotations = append(annotations, line)
+					if lead = strings.Join(annotations, ""); written {
+						lead = "\n" + lead
+					}
+				default:
+					if len(record) < 4 {
+						return fmt.Errorf("invalid row of annotated csv: %s", line)
+					}
+					t, err := parseTime(record[3], opts.Precision)
+					if err != nil {
+						return err
+					}
+					if err = rf.WritePointAfter(lead, line, t); err != nil {
+						return err
+					}
+					lead, written = "", true
+				}
+			}
+		})
+		if err != nil {
+			return rf, err
+		}
+	}
+	return rf, nil
+}
@@ -0,0 +1,152 @@
+package tool
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/influxdata/influxdb1-client/models"
+)
+
+func writeAnnotated(t *testing.T, dir, meas string, limit FileLimit, row *models.Row) []*FileStat {
+	fieldMap := map[string]string{"ok": "boolean", "usage": "float"}
+	base := filepath.Join(dir, meas)
+	aw := &annotatedWriter{
+		seriesColumns: newSeriesColumns([]string{"host"}, fieldMap, map[string]string{"host": "host"}, map[string]string{"ok": "ok", "usage": "usage"}, nil),
+		f:             newRotateFile(base, ".csv", false, limit, nil),
+		base:          base,
+		meas:          meas,
+		unit:          1e9,
+	}
+	if err := aw.WriteSeries(row); err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return aw.Stats()
+}
+
+func TestAnnotatedWriter(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	row := &models.Row{
+		Columns: []string{"time", "host", "ok", "usage"},
+		Values: [][]interface{}{
+			{json.Number("1600000000"), "h0", true, json.Number("0.5")},
+			{json.Number("1600000001"), nil, false, json.Number("2")},
+		},
+	}
+	stats := writeAnnotated(t, dir, "cpu", FileLimit{}, row)
+	// a table for each field type, in the order of double, long, boolean and string, separated by an empty line
+	want := []string{"#group,false,false,false,false,true,true,true\n" +
+		"#datatype,string,long,dateTime:RFC3339,double,string,string,string\n" +
+		"#default,_result,,,,,,\n" +
+		",result,table,_time,_value,_field,_measurement,host\n" +
+		",,0,2020-09-13T12:26:40Z,0.5,usage,cpu,h0\n" +
+		",,0,2020-09-13T12:26:41Z,2,usage,cpu,\n" +
+		"\n" +
+		"#group,false,false,false,false,true,true,true\n" +
+		"#datatype,string,long,dateTime:RFC3339,boolean,string,string,string\n" +
+		"#default,_result,,,,,,\n" +
+		",result,table,_time,_value,_field,_measurement,host\n" +
+		",,1,2020-09-13T12:26:40Z,true,ok,cpu,h0\n" +
+		",,1,2020-09-13T12:26:41Z,false,ok,cpu,\n"}
+	if got := readStats(t, stats); !reflect.DeepEqual(got, want) {
+		t.Errorf("annotated file = %q, want %q", got, want)
+	}
+	if stats[0].Points != 4 || stats[0].MinTime != 1600000000 || stats[0].MaxTime != 1600000001 {
+		t.Errorf("stat = %+v", stats[0])
+	}
+	if got := listDir(t, dir); !reflect.DeepEqual(got, []string{"cpu.csv"}) {
+		t.Errorf("files left = %v", got)
+	}
+
+	// every part starts with the annotations of the table it continues with
+	stats = writeAnnotated(t, dir, "mem", FileLimit{MaxLines: 3}, row)
+	double := "#group,false,false,false,false,true,true,true\n" +
+		"#datatype,string,long,dateTime:RFC3339,double,string,string,string\n" +
+		"#default,_result,,,,,,\n" +
+		",result,table,_time,_value,_field,_measurement,host\n"
+	boolean := "#group,false,false,false,false,true,true,true\n" +
+		"#datatype,string,long,dateTime:RFC3339,boolean,string,string,string\n" +
+		"#default,_result,,,,,,\n" +
+		",result,table,_time,_value,_field,_measurement,host\n"
+	want = []string{
+		double +
+			",,0,2020-09-13T12:26:40Z,0.5,usage,mem,h0\n" +
+			",,0,2020-09-13T12:26:41Z,2,usage,mem,\n" +
+			"\n" + boolean +
+			",,1,2020-09-13T12:26:40Z,true,ok,mem,h0\n",
+		boolean +
+			",,1,2020-09-13T12:26:41Z,false,ok,mem,\n",
+	}
+	if got := readStats(t, stats); !reflect.DeepEqual(got, want) {
+		t.Errorf("annotated parts = %q, want %q", got, want)
+	}
+	// a table starting a part is led by its annotations only once
+	stats = writeAnnotated(t, dir, "disk", FileLimit{MaxLines: 2}, row)
+	want = []string{
+		double +
+			",,0,2020-09-13T12:26:40Z,0.5,usage,disk,h0\n" +
+			",,0,2020-09-13T12:26:41Z,2,usage,disk,\n",
+		boolean +
+			",,1,2020-09-13T12:26:40Z,true,ok,disk,h0\n" +
+			",,1,2020-09-13T12:26:41Z,false,ok,disk,\n",
+	}
+	if got := readStats(t, stats); !reflect.DeepEqual(got, want) {
+		t.Errorf("annotated parts = %q, want %q", got, want)
+	}
+}
+
+func TestMergeAnnotatedFiles(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	row := &models.Row{
+		Columns: []string{"time", "host", "ok", "usage"},
+		Values: [][]interface{}{
+			{json.Number("1600000000"), "h0", true, json.Number("0.5")},
+		},
+	}
+	paths := make([]string, 0, 2)
+	for _, meas := range []string{"cpu", "mem"} {
+		for _, stat := range writeAnnotated(t, dir, meas, FileLimit{}, row) {
+			paths = append(paths, stat.Path)
+		}
+	}
+	opts := &ExportOptions{Dir: dir, Format: "annotated-csv", Precision: "s", Limit: FileLimit{MaxLines: 3}}
+	stats, err := MergeFiles(paths, "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	double := "#group,false,false,false,false,true,true,true\n" +
+		"#datatype,string,long,dateTime:RFC3339,double,string,string,string\n" +
+		"#default,_result,,,,,,\n" +
+		",result,table,_time,_value,_field,_measurement,host\n"
+	boolean := "#group,false,false,false,false,true,true,true\n" +
+		"#datatype,string,long,dateTime:RFC3339,boolean,string,string,string\n" +
+		"#default,_result,,,,,,\n" +
+		",result,table,_time,_value,_field,_measurement,host\n"
+	// tables are separated by an empty line, and a part continuing a table starts with its annotations
+	want := []string{
+		double +
+			",,0,2020-09-13T12:26:40Z,0.5,usage,cpu,h0\n" +
+			"\n" + boolean +
+			",,1,2020-09-13T12:26:40Z,true,ok,cpu,h0\n" +
+			"\n" + double +
+			",,0,2020-09-13T12:26:40Z,0.5,usage,mem,h0\n",
+		boolean +
+			",,1,2020-09-13T12:26:40Z,true,ok,mem,h0\n",
+	}
+	if got := readStats(t, stats); !reflect.DeepEqual(got, want) {
+		t.Errorf("merged parts = %q, want %q", got, want)
+	}
+	if stats[0].Points != 3 || stats[0].MinTime != 1600000000 || stats[1].Points != 1 {
+		t.Errorf("merged stats = %+v, %+v", stats[0], stats[1])
+	}
+	if got := listDir(t, dir); !reflect.DeepEqual(got, []string{"merge.0001.csv", "merge.0002.csv"}) {
+		t.Errorf("files left = %v", got)
+	}
+}
@@ -0,0 +1,162 @@
+package tool
+
+import (
+	"errors"
+	"io"
+
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/apache/arrow/go/arrow/memory"
+	"github.com/influxdata/influxdb1-client/models"
+)
+
+// arrowWriter writes the points of a measurement into an arrow ipc file, also known as feather v2,
+// with a timestamp column named time, string columns of tags and columns of fields typed by the field types.
+// The points are written as a record batch every ChunkSize rows. The file is created on the first write.
+type arrowWriter struct {
+	*seriesColumns
+	path      string
+	precision string
+
+	scale int64
+	of    *OutputFile
+	fw    *ipc.FileWriter
+	rb    *array.RecordBuilder
+	rows  int
+	stat  *FileStat
+}
+
+// offsetWriter counts the bytes written, as the arrow file writer only seeks the current offset.
+type offsetWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (ow *offsetWriter) Write(p []byte) (int, error) {
+	n, err := ow.w.Write(p)
+	ow.n += int64(n)
+	return n, err
+}
+
+func (ow *offsetWriter) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekCurrent {
+		return 0, errors.New("seek not supported")
+	}
+	return ow.n, nil
+}
+
+func (aw *arrowWriter) WriteSeries(row *models.Row) error {
+	return aw.decode(row, func(p *seriesPoint) error {
+		if aw.fw == nil {
+			if err := aw.open(); err != nil {
+				return err
+			}
+		}
+		record := aw.record(p)
+		aw.rb.Field(0).(*array.TimestampBuilder).Append(arrow.Timestamp(p.time * aw.scale))
+		for i, v := range record[1:] {
+			builder := aw.rb.Field(i + 1)
+			switch v := v.(type) {
+			case nil:
+				builder.AppendNull()
+			case float64:
+				builder.(*array.Float64Builder).Append(v)
+			case int64:
+				builder.(*array.Int64Builder).Append(v)
+			case bool:
+				builder.(*array.BooleanBuilder).Append(v)
+			case string:
+				builder.(*array.StringBuilder).Append(v)
+			}
+		}
+		aw.stat.add(p.time)
+		if aw.rows++; aw.rows >= ChunkSize {
+			return aw.flush()
+		}
+		return nil
+	})
+}
+
+func (aw *arrowWriter) open() (err error) {
+	timestamp := &arrow.TimestampType{TimeZone: "UTC"}
+	aw.scale = 1
+	switch aw.precision {
+	case "ns":
+		timestamp.Unit = arrow.Nanosecond
+	case "us":
+		timestamp.Unit = arrow.Microsecond
+	case "ms":
+		timestamp.Unit = arrow.Millisecond
+	default:
+		// timestamps in minutes or hours are stored in seconds
+		timestamp.Unit = arrow.Second
+		aw.scale = PrecisionUnit(aw.precision) / 1e9
+	}
+	fields := []arrow.Field{{Name: "time", Type: timestamp}}
+	for _, k := range aw.tagKeys {
+		fields = append(fields, arrow.Field{Name: aw.tagNames[k], Type: arrow.BinaryTypes.String, Nullable: true})
+	}
+	for _, k := range aw.fieldKeys {
+		var dt arrow.DataType
+		switch aw.fieldMap[k] {
+		case "float":
+			dt = arrow.PrimitiveTypes.Float64
+		case "integer":
+			dt = arrow.PrimitiveTypes.Int64
+		case "boolean":
+			dt = arrow.FixedWidthTypes.Boolean
+		default:
+			dt = arrow.BinaryTypes.String
+		}
+		fields = append(fields, arrow.Field{Name: aw.fieldNames[k], Type: dt, Nullable: true})
+	}
+	schema := arrow.NewSchema(fields, nil)
+
+	if aw.of, err = CreateOutputFile(aw.path, false); err != nil {
+		return
+	}
+	aw.stat = &FileStat{Path: aw.path}
+	mem := memory.NewGoAllocator()
+	if aw.fw, err = ipc.NewFileWriter(&offsetWriter{w: aw.of}, ipc.WithSchema(schema), ipc.WithAllocator(mem)); err != nil {
+		return
+	}
+	aw.rb = array.NewRecordBuilder(mem, schema)
+	return
+}
+
+// flush writes the rows built as a record batch.
+func (aw *arrowWriter) flush() error {
+	rec := aw.rb.NewRecord()
+	defer rec.Release()
+	aw.rows = 0
+	return aw.fw.Write(rec)
+}
+
+// Close writes the rest rows and the footer of the arrow file.
+func (aw *arrowWriter) Close() (err error) {
+	if aw.of == nil {
+		return nil
+	}
+	if aw.fw != nil {
+		if aw.rows > 0 {
+			err = aw.flush()
+		}
+		if cerr := aw.fw.Close(); err == nil {
+			err = cerr
+		}
+		aw.rb.Release()
+	}
+	if cerr := aw.of.Close(); err == nil {
+		err = cerr
+	}
+	aw.stat.Size, aw.stat.SHA256 = aw.of.Size(), aw.of.Sum()
+	return
+}
+
+func (aw *arrowWriter) Stats() []*FileStat {
+	if aw.stat == nil {
+		return nil
+	}
+	return []*FileStat{aw.stat}
+}
@@ -0,0 +1,63 @@
+package tool
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/apache/arrow/go/arrow/memory"
+	"github.com/influxdata/influxdb1-client/models"
+)
+
+func TestArrowWriter(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	// num is cast to float from string, while mixed is a field of float and string with its companion column
+	fieldMap := map[string]string{"mixed": "float", "num": "float", "ok": "boolean"}
+	aw := &arrowWriter{
+		seriesColumns: newSeriesColumns([]string{"host"}, fieldMap, map[string]string{"host": "host"}, map[string]string{"mixed": "mixed", "num": "num", "ok": "ok"}, []string{"mixed"}),
+		path:          filepath.Join(dir, "cpu.arrow"),
+		precision:     "ns",
+	}
+	row := &models.Row{
+		Columns: []string{"time", "host", "mixed", "num", "ok", "mixed_1"},
+		Values: [][]interface{}{
+			{json.Number("1"), "h0", json.Number("0.5"), "1.5", true, nil},
+			{json.Number("2"), nil, nil, "bad", nil, "2.5"},
+			{json.Number("3"), "h1", nil, nil, false, "x'); DROP TABLE cpu; --"},
+		},
+	}
+	if err := aw.WriteSeries(row); err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if stat := aw.Stats()[0]; stat.Points != 3 || stat.MinTime != 1 || stat.MaxTime != 3 {
+		t.Errorf("stat = %+v", stat)
+	}
+
+	f, err := os.Open(aw.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fr, err := ipc.NewFileReader(f, ipc.WithAllocator(memory.NewGoAllocator()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fr.Close()
+	rec, err := fr.Record(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`["h0" (null) "h1"]`, `[0.5 2.5 (null)]`, `[1.5 (null) (null)]`, `[true (null) false]`}
+	for i, w := range want {
+		if got := fmt.Sprint(rec.Column(i + 1)); got != w {
+			t.Errorf("column %s = %s, want %s", rec.ColumnName(i+1), got, w)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package tool
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// CheckpointFile is the name of the checkpoint file in the export directory,
+// with an extension of no export format so that it never collides with the files of a measurement.
+const CheckpointFile = "checkpoint.log"
+
+// CheckpointEntry records a measurement exported completely in the time window from Start to End,
+// with the fingerprint of the export options the files were written with.
+type CheckpointEntry struct {
+	Database        string      `json:"database"`
+	RetentionPolicy string      `json:"retention_policy"`
+	Measurement     string      `json:"measurement"`
+	Start           int64       `json:"start"`
+	End             int64       `json:"end"`
+	Fingerprint     string      `json:"fingerprint"`
+	Files           []*FileStat `json:"files"`
+	Watermark       *int64      `json:"watermark,omitempty"` // watermark to advance by incremental export, nil if nothing written
+}
+
+func (ce *CheckpointEntry) key() string {
+	return ce.Database + "\x00" + ce.RetentionPolicy + "\x00" + ce.Measurement
+}
+
+// Checkpoint appends an entry as a json line for each completed measurement,
+// so that the entries written before a crash are kept.
+type Checkpoint struct {
+	path string
+	mu   sync.Mutex
+	f    *os.File
+	done map[string]*CheckpointEntry
+}
+
+// OpenCheckpoint opens the checkpoint file in dir, loading its entries to resume from if resume is true,
+// otherwise it starts over.
+func OpenCheckpoint(dir string, resume bool) (*Checkpoint, error) {
+	cp := &Checkpoint{path: filepath.Join(dir, CheckpointFile), done: make(map[string]*CheckpointEntry)}
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resume {
+		if err := cp.load(); err != nil {
+			return nil, err
+		}
+		flag = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(cp.path, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	cp.f = f
+	return cp, nil
+}
+
+func (cp *Checkpoint) load() error {
+	f, err := os.Open(cp.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), WriterBufferSize)
+	for scanner.Scan() {
+		entry := &CheckpointEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			// the last line may be partially written by a crash
+			continue
+		}
+		cp.done[entry.key()] = entry
+	}
+	return scanner.Err()
+}
+
+// Done returns the entry of the measurement if exported completely in the same time window
+// with the same fingerprint of export options, or nil otherwise.
+func (cp *Checkpoint) Done(db, rp, meas string, start, end int64, fingerprint string) *CheckpointEntry {
+	entry := cp.done[(&CheckpointEntry{Database: db, RetentionPolicy: rp, Measurement: meas}).key()]
+	if entry == nil || entry.Start != start || entry.End != end || entry.Fingerprint != fingerprint {
+		return nil
+	}
+	for _, stat := range entry.Files {
+		if _, err := os.Stat(stat.Path); err != nil {
+			return nil
+		}
+	}
+	return entry
+}
+
+// Complete records the entry of a measurement exported completely.
+func (cp *Checkpoint) Complete(entry *CheckpointEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if _, err = cp.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return cp.f.Sync()
+}
+
+// Remove closes and removes the checkpoint file, when nothing is left to resume.
+func (cp *Checkpoint) Remove() error {
+	cp.f.Close()
+	return os.Remove(cp.path)
+}
+
+// Close closes the checkpoint file.
+func (cp *Checkpoint) Close() error {
+	return cp.f.Close()
+}
@@ -0,0 +1,55 @@
+package tool
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpointDone(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	opts := &ExportOptions{Format: "line", Precision: "ns"}
+	fingerprint := opts.Fingerprint()
+	path := filepath.Join(dir, "cpu.txt")
+	writeFiles(t, dir, [][2]string{{"cpu.txt", "cpu v=1 1\n"}})
+
+	cp, err := OpenCheckpoint(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := &CheckpointEntry{Database: "db", RetentionPolicy: "rp", Measurement: "cpu", Start: 0, End: 10,
+		Fingerprint: fingerprint, Files: []*FileStat{{Path: path}}}
+	if err = cp.Complete(entry); err != nil {
+		t.Fatal(err)
+	}
+	cp.Close()
+
+	if cp, err = OpenCheckpoint(dir, true); err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+	if cp.Done("db", "rp", "cpu", 0, 10, fingerprint) == nil {
+		t.Error("entry not resumed")
+	}
+	if cp.Done("db", "rp", "cpu", 0, 20, fingerprint) != nil {
+		t.Error("entry resumed in another time range")
+	}
+	for _, other := range []*ExportOptions{
+		{Format: "csv", Precision: "ns"},
+		{Format: "line", Precision: "s"},
+		{Format: "line", Precision: "ns", Where: "host = 'h0'"},
+		{Format: "line", Precision: "ns", Compress: true},
+		{Format: "line", Precision: "ns", Limit: FileLimit{MaxLines: 10}},
+		{Format: "line", Precision: "ns", Projection: NewProjection("host", "", "", "")},
+		{Format: "line", Precision: "ns", Mapping: &Mapping{Measurements: map[string]string{"cpu": "cpu2"}}},
+	} {
+		if cp.Done("db", "rp", "cpu", 0, 10, other.Fingerprint()) != nil {
+			t.Errorf("entry resumed with other options %+v", other)
+		}
+	}
+	os.Remove(path)
+	if cp.Done("db", "rp", "cpu", 0, 10, fingerprint) != nil {
+		t.Error("entry resumed without its files")
+	}
+}
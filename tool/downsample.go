@@ -0,0 +1,142 @@
+package tool
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/util"
+	"github.com/influxdata/influxql"
+)
+
+var (
+	numericTypes = []string{"float", "integer"}
+	allTypes     = []string{"float", "integer", "string", "boolean"}
+
+	// aggregateTypes are the field types each aggregate function accepts
+	aggregateTypes = map[string][]string{
+		"count":  allTypes,
+		"first":  allTypes,
+		"last":   allTypes,
+		"mode":   allTypes,
+		"max":    numericTypes,
+		"min":    numericTypes,
+		"mean":   numericTypes,
+		"median": numericTypes,
+		"spread": numericTypes,
+		"stddev": numericTypes,
+		"sum":    numericTypes,
+	}
+)
+
+// Downsample aggregates each measurement into GROUP BY time() intervals.
+type Downsample struct {
+	Interval    time.Duration
+	Fill        string
+	Measurement string // output measurement name, where {measurement} is replaced by the measurement name
+
+	// aggregates maps a field type, a field key or 'measurement:field' to the aggregate function,
+	// where 'measurement:field' takes precedence over the field key, and the field key over the field type
+	aggregates map[string]string
+}
+
+// NewDownsample parses the aggregates given as 'type=function', 'field=function' or 'measurement:field=function'
+// split by ',', such as float=mean,integer=mean,string=last,boolean=last.
+func NewDownsample(interval time.Duration, aggregates, fill, measurement string) (*Downsample, error) {
+	ds := &Downsample{Interval: interval, Fill: fill, Measurement: measurement, aggregates: make(map[string]string)}
+	for _, item := range util.String2Array(aggregates) {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid aggregate: %s", item)
+		}
+		fn := strings.ToLower(kv[1])
+		if _, ok := aggregateTypes[fn]; !ok {
+			return nil, fmt.Errorf("unsupported aggregate function: %s", kv[1])
+		}
+		ds.aggregates[kv[0]] = fn
+	}
+	switch fill {
+	case "none", "null", "previous", "linear":
+	default:
+		if _, err := strconv.ParseFloat(fill, 64); err != nil {
+			return nil, fmt.Errorf("invalid fill: %s", fill)
+		}
+	}
+	if measurement == "" {
+		ds.Measurement = "{measurement}"
+	}
+	return ds, nil
+}
+
+func (ds *Downsample) aggregate(meas, field, vtype string) string {
+	if fn, ok := ds.aggregates[meas+":"+field]; ok {
+		return fn
+	}
+	if fn, ok := ds.aggregates[field]; ok {
+		return fn
+	}
+	return ds.aggregates[vtype]
+}
+
+// OutputMeasurement returns the name of the measurement the aggregates are written to.
+func (ds *Downsample) OutputMeasurement(meas string) string {
+	return strings.ReplaceAll(ds.Measurement, "{measurement}", meas)
+}
+
+// reform returns the field types of the aggregates and the select clause of the measurement.
+// The aggregate legal for a field is decided by the type InfluxDB returns for it, which is the single type
+// of the field, or the type chosen by reformFieldKeys if the field has multiple types. Fields without a
+// legal aggregate are skipped.
+func (ds *Downsample) reform(meas string, fieldKeys map[string][]string, fieldMap map[string]string) (aggMap map[string]string, keyClause string) {
+	fields := make([]string, 0, len(fieldMap))
+	for field := range fieldMap {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	aggMap = make(map[string]string, len(fields))
+	selects := make([]string, 0, len(fields))
+	for _, field := range fields {
+		qtype := fieldMap[field]
+		if types := fieldKeys[field]; len(types) == 1 {
+			qtype = types[0]
+		}
+		fn := ds.aggregate(meas, field, qtype)
+		if fn == "" {
+			continue
+		}
+		if !util.NewSetFromSlice(aggregateTypes[fn])[qtype] {
+			fmt.Printf("skip field %s on %s: aggregate %s not supported on %s\n", field, meas, fn, qtype)
+			continue
+		}
+		switch fn {
+		case "count":
+			aggMap[field] = "integer"
+		case "mean", "median", "stddev":
+			aggMap[field] = "float"
+		case "first", "last", "mode":
+			// cast to the type of -boolean-fields, -float-fields or -integer-fields like the raw values
+			aggMap[field] = fieldMap[field]
+		default:
+			aggMap[field] = qtype
+		}
+		quoted := influxql.QuoteIdent(field)
+		selects = append(selects, fmt.Sprintf("%s(%s) AS %s", fn, quoted, quoted))
+	}
+	keyClause = strings.Join(selects, ", ")
+	return
+}
+
+// groupClause groups by all tags, or only by the given tag keys if projected.
+func (ds *Downsample) groupClause(tagKeys []string, projected bool) string {
+	dims := []string{fmt.Sprintf("time(%s)", influxql.FormatDuration(ds.Interval))}
+	if !projected {
+		dims = append(dims, "*")
+	} else {
+		for _, key := range tagKeys {
+			dims = append(dims, influxql.QuoteIdent(key))
+		}
+	}
+	return fmt.Sprintf("group by %s fill(%s)", strings.Join(dims, ", "), ds.Fill)
+}
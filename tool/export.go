@@ -1,12 +1,16 @@
 package tool
 
 import (
-	"encoding/csv"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/chengshiwen/influx-tool/backend"
 	"github.com/chengshiwen/influx-tool/util"
@@ -14,7 +18,17 @@ import (
 	"github.com/influxdata/influxql"
 )
 
-func reformFieldKeys(fieldKeys map[string][]string, castFields map[string][]string) (fieldMap map[string]string, keyClause string) {
+const (
+	// ChunkSize is the number of points per chunk requested from a chunked query.
+	ChunkSize = 10000
+	// WriterBufferSize is the buffer size used when writing export files.
+	WriterBufferSize = 1 << 20
+)
+
+// reformFieldKeys returns the type of every field, and the select clause with a companion select appended
+// for each field of mixed types. The companions are the fields of these selects in order, whose columns
+// are returned last.
+func reformFieldKeys(fieldKeys map[string][]string, castFields map[string][]string, selects []string) (fieldMap map[string]string, keyClause string, companions []string) {
 	// The SELECT statement returns all field values if all values have the same type.
 	// If field value types differ across shards, InfluxDB first performs any applicable cast operations and
 	// then returns all values with the type that occurs first in the following list: float, integer, string, boolean.
@@ -22,9 +36,14 @@ func reformFieldKeys(fieldKeys map[string][]string, castFields map[string][]stri
 	for field, types := range fieldKeys {
 		fieldSet[field] = util.NewSetFromSlice(types)
 	}
+	fields := make([]string, 0, len(fieldKeys))
+	for field := range fieldKeys {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
 	fieldMap = make(map[string]string, len(fieldKeys))
-	selects := []string{"*"}
-	for field, types := range fieldKeys {
+	for _, field := range fields {
+		types := fieldKeys[field]
 		if len(types) == 1 {
 			fieldMap[field] = types[0]
 		} else {
@@ -41,11 +60,13 @@ func reformFieldKeys(fieldKeys map[string][]string, castFields map[string][]stri
 				}
 				if sok {
 					selects = append(selects, fmt.Sprintf("\"%s\"::string", field))
+					companions = append(companions, field)
 				}
 				// discard boolean
 			} else {
 				fieldMap[field] = "boolean"
 				selects = append(selects, fmt.Sprintf("\"%s\"::boolean", field))
+				companions = append(companions, field)
 			}
 		}
 	}
@@ -61,161 +82,612 @@ func reformFieldKeys(fieldKeys map[string][]string, castFields map[string][]stri
 	return
 }
 
-func GetDMLHeader(db string) string {
-	return strings.Join([]string{
+func GetDMLHeader(db string, rp *backend.RetentionPolicy, precision string) string {
+	return strings.Join(append([]string{
 		"# DDL",
-		fmt.Sprintf("CREATE DATABASE %s WITH NAME autogen", influxql.QuoteIdent(db)),
+		GetDDL(db, []*backend.RetentionPolicy{rp}),
 		"# DML",
+	}, precisionHeader(precision, GetContextHeader(db, rp))...), "\n")
+}
+
+// GetMergeHeader returns the header of a merged file with the DDL of all the databases,
+// since influx -import only executes the DDL section at the beginning of a file.
+// Each merged file starts with its own context header to switch to the database and retention policy it belongs to.
+func GetMergeHeader(ddls []string, precision string) string {
+	return strings.Join(precisionHeader(precision, "# DDL", strings.Join(ddls, "\n"), "# DML"), "\n")
+}
+
+// precisionHeader documents the timestamp precision if not nanosecond, which influx -import
+// cannot read from the file, but from its -precision option.
+func precisionHeader(precision string, lines ...string) []string {
+	if precision == "" || precision == "ns" {
+		return lines
+	}
+	return append([]string{fmt.Sprintf("# PRECISION:%s (import with influx -import -precision %s)", precision, Epoch(precision))}, lines...)
+}
+
+// Epoch returns the epoch query parameter of the timestamp precision.
+func Epoch(precision string) string {
+	if precision == "us" {
+		return "u"
+	}
+	return precision
+}
+
+// PrecisionUnit returns the nanoseconds of one unit of the timestamp precision, or 0 if invalid.
+func PrecisionUnit(precision string) int64 {
+	switch precision {
+	case "ns":
+		return 1
+	case "us":
+		return int64(time.Microsecond)
+	case "ms":
+		return int64(time.Millisecond)
+	case "s":
+		return int64(time.Second)
+	case "m":
+		return int64(time.Minute)
+	case "h":
+		return int64(time.Hour)
+	}
+	return 0
+}
+
+func GetContextHeader(db string, rp *backend.RetentionPolicy) string {
+	return strings.Join([]string{
 		fmt.Sprintf("# CONTEXT-DATABASE:%s", db),
-		"# CONTEXT-RETENTION-POLICY:autogen",
+		fmt.Sprintf("# CONTEXT-RETENTION-POLICY:%s", rp.Name),
 	}, "\n")
 }
 
-func Export(be *backend.Backend, db, meas string, start, end int64, dir string, castFields map[string][]string, merge bool) (err error) {
-	tagKeys := be.GetTagKeys(db, meas)
-	tagMap := util.NewSetFromSlice(tagKeys)
-	fieldKeys := be.GetFieldKeys(db, meas)
-	fieldMap, keyClause := reformFieldKeys(fieldKeys, castFields)
+// GetDDL returns the statements to recreate the database with the retention policies.
+// The default retention policy is created along with the database, like influx_inspect export does.
+func GetDDL(db string, rps []*backend.RetentionPolicy) string {
+	ddl := []string{fmt.Sprintf("CREATE DATABASE %s", influxql.QuoteIdent(db))}
+	for _, rp := range rps {
+		spec := fmt.Sprintf("DURATION %s REPLICATION %d SHARD DURATION %s",
+			influxql.FormatDuration(rp.Duration), rp.Replication, influxql.FormatDuration(rp.ShardGroupDuration))
+		if rp.Default {
+			ddl[0] = fmt.Sprintf("CREATE DATABASE %s WITH %s NAME %s", influxql.QuoteIdent(db), spec, influxql.QuoteIdent(rp.Name))
+		} else {
+			ddl = append(ddl, fmt.Sprintf("CREATE RETENTION POLICY %s ON %s %s", influxql.QuoteIdent(rp.Name), influxql.QuoteIdent(db), spec))
+		}
+	}
+	return strings.Join(ddl, "\n")
+}
 
-	whereClause := fmt.Sprintf("where time >= %ds and time <= %ds", start, end)
-	q := fmt.Sprintf("select %s from \"%s\" %s", keyClause, util.EscapeIdentifier(meas), whereClause)
-	rsp, err := be.QueryIQL("GET", db, q, "ns")
+// ParseWhere parses and validates a condition on tags and fields, such as region='eu' AND env!='dev',
+// and returns it formatted by influxql so that it can be safely embedded into the WHERE clause.
+func ParseWhere(where string) (string, error) {
+	p := influxql.NewParser(strings.NewReader(where))
+	expr, err := p.ParseExpr()
 	if err != nil {
+		return "", err
+	}
+	// the expression is parsed up to the first token it can not take, which must be the end
+	if tok, pos, lit := p.ScanIgnoreWhitespace(); tok != influxql.EOF {
+		if lit == "" {
+			lit = tok.String()
+		}
+		return "", fmt.Errorf("found %s, expected EOF at line %d, char %d", lit, pos.Line+1, pos.Char+1)
+	}
+	cond := expr
+	for {
+		if paren, ok := cond.(*influxql.ParenExpr); ok {
+			cond = paren.Expr
+		} else {
+			break
+		}
+	}
+	if bin, ok := cond.(*influxql.BinaryExpr); !ok || !isConditionOp(bin.Op) {
+		return "", fmt.Errorf("not a condition: %s", where)
+	}
+	var invalid error
+	influxql.WalkFunc(expr, func(n influxql.Node) {
+		switch n := n.(type) {
+		case *influxql.Call, *influxql.Wildcard, *influxql.Distinct:
+			if invalid == nil {
+				invalid = fmt.Errorf("invalid expression in condition: %s", n)
+			}
+		}
+	})
+	if invalid != nil {
+		return "", invalid
+	}
+	return cond.String(), nil
+}
+
+func isConditionOp(op influxql.Token) bool {
+	switch op {
+	case influxql.AND, influxql.OR, influxql.EQ, influxql.NEQ, influxql.EQREGEX, influxql.NEQREGEX,
+		influxql.LT, influxql.LTE, influxql.GT, influxql.GTE:
+		return true
+	}
+	return false
+}
+
+// ExportOptions holds the options shared by every measurement of an export.
+type ExportOptions struct {
+	Database         string
+	RetentionPolicy  *backend.RetentionPolicy // retention policy to read from, recreated by the DDL header
+	Start            int64                    // unix time in seconds, included
+	End              int64                    // unix time in seconds, included
+	Where            string                   // condition ANDed into the time range, validated by ParseWhere
+	Projection       *Projection              // tag keys and field keys to export, all if nil
+	Mapping          *Mapping                 // names to rename on export, nothing renamed if nil
+	Downsample       *Downsample              // aggregates to export instead of raw data, disabled if nil
+	Window           int64                    // time window in nanoseconds to slice each measurement into, disabled if 0
+	Dir              string
+	Format           string
+	Precision        string         // timestamp precision of exported data, as ns, us, ms, s, m or h
+	Location         *time.Location // time zone to write RFC3339 timestamps into csv, epoch timestamps if nil
+	Merge            bool
+	Compress         bool             // write gzip-compressed files
+	Limit            FileLimit        // size and lines to rotate export files into numbered parts, unlimited if zero
+	SQLBatch         int              // rows per INSERT statement of sql dumps
+	Hypertable       bool             // create the tables of sql dumps as TimescaleDB hypertables
+	SQLite           *SQLiteDB        // database to export into as tables when the format is sqlite
+	NonNumeric       string           // policy of string and boolean fields in the prometheus format, in NonNumericPolicies
+	GraphiteTemplate GraphiteTemplate // template of metric paths in the graphite format
+	CastFields       map[string][]string
+}
+
+// Fingerprint returns the fingerprint of the options deciding the content, format and paths of the export files,
+// so that the files written with other options are never resumed. The time range is compared on its own.
+func (opts *ExportOptions) Fingerprint() string {
+	fp := struct {
+		Format           string
+		Precision        string
+		Location         string
+		Where            string
+		Projection       string
+		Mapping          *Mapping
+		Downsample       string
+		Merge            bool
+		Compress         bool
+		Limit            FileLimit
+		SQLBatch         int
+		Hypertable       bool
+		NonNumeric       string
+		GraphiteTemplate GraphiteTemplate
+		CastFields       map[string][]string
+	}{
+		Format:           opts.Format,
+		Precision:        opts.Precision,
+		Where:            opts.Where,
+		Mapping:          opts.Mapping,
+		Merge:            opts.Merge,
+		Compress:         opts.Compress,
+		Limit:            opts.Limit,
+		SQLBatch:         opts.SQLBatch,
+		Hypertable:       opts.Hypertable,
+		NonNumeric:       opts.NonNumeric,
+		GraphiteTemplate: opts.GraphiteTemplate,
+		CastFields:       opts.CastFields,
+	}
+	if opts.Location != nil {
+		fp.Location = opts.Location.String()
+	}
+	// maps are formatted in key order
+	if opts.Projection != nil {
+		fp.Projection = fmt.Sprintf("%+v", *opts.Projection)
+	}
+	if opts.Downsample != nil {
+		fp.Downsample = fmt.Sprintf("%+v", *opts.Downsample)
+	}
+	data, _ := json.Marshal(fp)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// MeasurementExport exports one measurement, which is split into one or more time slices.
+// Slices may be exported concurrently, but are always written to the output file in time order:
+// the slice next in line is written directly, others are spooled to disk until their turn comes.
+type MeasurementExport struct {
+	be   *backend.Backend
+	opts *ExportOptions
+	meas string
+
+	tagKeys     []string
+	fieldMap    map[string]string
+	keyClause   string
+	groupClause string
+	companions  []string
+	slices      []timeSlice
+
+	// OnDone is called once when all slices have been written.
+	OnDone func(err error)
+
+	mu      sync.Mutex
+	next    int
+	writing bool
+	done    []bool
+	spools  []string
+	w       seriesWriter
+	err     error
+	resumed []*FileStat
+
+	since       int64
+	incremental bool
+	last        int64 // time of the last point written, in the precision
+	written     bool
+}
+
+func NewMeasurementExport(be *backend.Backend, opts *ExportOptions, meas string) *MeasurementExport {
+	return &MeasurementExport{be: be, opts: opts, meas: meas}
+}
+
+func (me *MeasurementExport) Measurement() string {
+	return me.meas
+}
+
+// Resume marks the measurement as exported by a previous run into the files,
+// so that it is neither prepared nor exported again.
+func (me *MeasurementExport) Resume(files []*FileStat) {
+	me.resumed = append([]*FileStat{}, files...)
+}
+
+// Files returns the stats of the files written, which is only complete after all slices have been written.
+func (me *MeasurementExport) Files() []*FileStat {
+	if me.resumed != nil {
+		return me.resumed
+	}
+	if me.w == nil {
+		return nil
+	}
+	return me.w.Stats()
+}
+
+// ManifestEntry returns the entry of the files of the measurement in the manifest.
+func (me *MeasurementExport) ManifestEntry() ManifestEntry {
+	return ManifestEntry{
+		Database:        me.opts.Database,
+		RetentionPolicy: me.opts.RetentionPolicy.Name,
+		Measurement:     me.meas,
+		Format:          me.opts.Format,
+		Precision:       me.opts.Precision,
+		Compressed:      me.opts.Compress,
+	}
+}
+
+// Paths returns the paths of the files written, which is only complete after all slices have been written.
+func (me *MeasurementExport) Paths() []string {
+	paths := make([]string, 0)
+	for _, stat := range me.Files() {
+		paths = append(paths, stat.Path)
+	}
+	return paths
+}
+
+// Prepare resolves the tag keys, field types and time slices of the measurement.
+// It must be called before any slice is exported.
+func (me *MeasurementExport) Prepare() {
+	// files or tables left by a previous run, possibly partial, are replaced
+	if me.opts.SQLite != nil {
+		me.err = me.opts.SQLite.DropTable(me.targetMeasurement())
+	} else {
+		me.err = removeParts(filepath.Join(me.opts.Dir, me.meas), FileExt(me.opts.Format, me.opts.Compress), nil)
+	}
+	if me.err != nil {
+		me.finish()
 		return
 	}
-	series, err := backend.SeriesFromResponseBytes(rsp)
-	if err != nil {
+	defer func() {
+		if r := recover(); r != nil {
+			me.err, me.slices = fmt.Errorf("prepare error: %v", r), nil
+			me.finish()
+		}
+	}()
+	db, rp, meas := me.opts.Database, me.opts.RetentionPolicy.Name, me.meas
+	if me.tagKeys, me.err = me.be.GetTagKeys(db, rp, meas); me.err != nil {
+		me.finish()
 		return
 	}
-	if len(series) < 1 {
-		fmt.Printf("select empty data from %s on %s\n", db, meas)
+	fieldKeys, err := me.be.GetFieldKeys(db, rp, meas)
+	if err != nil {
+		me.err = err
+		me.finish()
 		return
 	}
-	columns := series[0].Columns
+	selects := []string{"*"}
+	if proj := me.opts.Projection; proj != nil {
+		me.tagKeys = proj.TagKeys(meas, me.tagKeys)
+		fieldKeys = proj.FieldKeys(meas, fieldKeys)
+		selects = selectKeys(me.tagKeys, fieldKeys)
+	}
+	me.fieldMap, me.keyClause, me.companions = reformFieldKeys(fieldKeys, me.opts.CastFields, selects)
+	if ds := me.opts.Downsample; ds != nil {
+		// tags are returned by group by instead of columns
+		me.fieldMap, me.keyClause = ds.reform(meas, fieldKeys, me.fieldMap)
+		me.companions = nil
+		me.groupClause = " " + ds.groupClause(me.tagKeys, me.opts.Projection != nil)
+	}
 
-	defer func() {
-		if err := recover(); err != nil {
-			fmt.Printf("multi data type error from %s on %s: %s\n", err, db, meas)
+	// a measurement without any selected field has nothing to export
+	if len(me.fieldMap) > 0 {
+		if me.opts.Window > 0 || me.opts.Downsample != nil {
+			me.slices, me.err = me.windowSlices()
+		} else {
+			me.slices = []timeSlice{{
+				whereClause: me.whereClause(me.timeCond()),
+			}}
 		}
-	}()
+	}
+	if me.err != nil {
+		me.slices = nil
+	}
+	me.done = make([]bool, len(me.slices))
+	me.spools = make([]string, len(me.slices))
+	if len(me.slices) == 0 {
+		me.finish()
+	}
+}
 
-	var lines []string
-	if !merge {
-		lines = append(lines, GetDMLHeader(db))
-	}
-	headerTotal := 1 + len(tagKeys) + len(fieldKeys)
-	for _, value := range series[0].Values {
-		mtagSet := []string{util.EscapeMeasurement(meas)}
-		fieldSet := make([]string, 0)
-		for i := 1; i < len(value); i++ {
-			k := columns[i]
-			v := value[i]
-			if tagMap[k] {
-				if v != nil {
-					mtagSet = append(mtagSet, fmt.Sprintf("%s=%s", util.EscapeTag(k), util.EscapeTag(v.(string))))
-				}
-			} else {
-				if i >= headerTotal {
-					if idx := strings.LastIndex(k, "_"); idx > -1 {
-						k = k[:idx]
-					}
-				}
-				if vtype, ok := fieldMap[k]; ok && v != nil {
-					if vtype == "float" || vtype == "boolean" {
-						fieldSet = append(fieldSet, fmt.Sprintf("%s=%v", util.EscapeTag(k), v))
-					} else if vtype == "integer" {
-						fieldSet = append(fieldSet, fmt.Sprintf("%s=%vi", util.EscapeTag(k), v))
-					} else if vtype == "string" {
-						fieldSet = append(fieldSet, fmt.Sprintf("%s=\"%s\"", util.EscapeTag(k), models.EscapeStringField(v.(string))))
-					}
-				}
+// Slices returns the number of time slices to export.
+func (me *MeasurementExport) Slices() int {
+	return len(me.slices)
+}
+
+// ExportSlice exports the i-th time slice of the measurement.
+func (me *MeasurementExport) ExportSlice(i int) {
+	me.mu.Lock()
+	direct := i == me.next && !me.writing && me.err == nil
+	if direct {
+		me.writing = true
+	}
+	failed := me.err != nil
+	me.mu.Unlock()
+
+	var err error
+	if direct {
+		err = me.query(me.slices[i], me.writeSeries)
+	} else if !failed {
+		me.spools[i] = filepath.Join(me.opts.Dir, fmt.Sprintf("%s.%d.spool", me.meas, i))
+		err = me.spool(me.slices[i], me.spools[i])
+	}
+
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if err != nil && me.err == nil {
+		me.err = err
+	}
+	me.done[i] = true
+	if direct {
+		me.writing = false
+		me.next++
+	}
+	me.drain()
+}
+
+// drain writes the spooled slices next in line, must be called with me.mu held.
+func (me *MeasurementExport) drain() {
+	for !me.writing && me.next < len(me.slices) && me.done[me.next] {
+		i := me.next
+		me.writing = true
+		failed := me.err != nil
+		me.mu.Unlock()
+		var err error
+		if spool := me.spools[i]; spool != "" {
+			if !failed {
+				err = replaySpool(spool, me.writeSeries)
 			}
+			os.Remove(spool)
 		}
-		mtagStr := strings.Join(mtagSet, ",")
-		fieldStr := strings.Join(fieldSet, ",")
-		line := fmt.Sprintf("%s %s %v\n", mtagStr, fieldStr, value[0])
-		lines = append(lines, line)
+		me.mu.Lock()
+		if err != nil && me.err == nil {
+			me.err = err
+		}
+		me.writing = false
+		me.next++
 	}
-	if len(lines) != 0 {
-		data := []byte(strings.Join(lines, "\n") + "\n")
-		ioutil.WriteFile(filepath.Join(dir, meas+".txt"), data, 0644)
+	if me.next == len(me.slices) && !me.writing {
+		me.finish()
 	}
-	return
 }
 
-func ExportCsv(be *backend.Backend, db, meas string, start, end int64, dir string, castFields map[string][]string) (err error) {
-	tagKeys := be.GetTagKeys(db, meas)
-	tagMap := util.NewSetFromSlice(tagKeys)
-	fieldKeys := be.GetFieldKeys(db, meas)
-	fieldMap, keyClause := reformFieldKeys(fieldKeys, castFields)
-
-	whereClause := fmt.Sprintf("where time >= %ds and time <= %ds", start, end)
-	q := fmt.Sprintf("select %s from \"%s\" %s", keyClause, util.EscapeIdentifier(meas), whereClause)
-	rsp, err := be.QueryIQL("GET", db, q, "ns")
-	if err != nil {
-		return
+func (me *MeasurementExport) finish() {
+	if me.w != nil {
+		if err := me.w.Close(); err != nil && me.err == nil {
+			me.err = err
+		}
+	} else if me.err == nil {
+		fmt.Printf("select empty data from %s on %s\n", me.opts.Database, me.meas)
 	}
-	series, err := backend.SeriesFromResponseBytes(rsp)
-	if err != nil {
-		return
+	if me.err != nil {
+		fmt.Printf("export error from %s on %s: %s\n", me.opts.Database, me.meas, me.err)
 	}
-	if len(series) < 1 {
-		fmt.Printf("select empty data from %s on %s\n", db, meas)
-		return
+	if me.OnDone != nil {
+		me.OnDone(me.err)
 	}
-	columns := series[0].Columns
+}
 
+func (me *MeasurementExport) query(ts timeSlice, fn func(series models.Rows) error) (err error) {
 	defer func() {
-		if err := recover(); err != nil {
-			fmt.Printf("export panic from %s on %s: %s\n", err, db, meas)
+		if r := recover(); r != nil {
+			err = fmt.Errorf("multi data type error: %v", r)
 		}
 	}()
+	q := fmt.Sprintf("select %s from %s %s%s", me.keyClause, me.source(), ts.whereClause, me.groupClause)
+	return me.be.QueryIQLChunk("GET", me.opts.Database, q, Epoch(me.opts.Precision), ChunkSize, fn)
+}
 
-	w, err := os.Create(filepath.Join(dir, meas+".csv"))
-	if err != nil {
-		panic(err)
-	}
-	defer w.Close()
-	w.WriteString("\xEF\xBB\xBF")
-	csvw := csv.NewWriter(w)
-	headerTotal := 1 + len(tagKeys) + len(fieldKeys)
-	csvHeaders := append([]string{"name"}, columns[:headerTotal]...)
-	csvw.Write(csvHeaders)
-
-	for _, value := range series[0].Values {
-		records := make([]string, 0, headerTotal+1)
-		records = append(records, meas)
-		records = append(records, fmt.Sprintf("%v", value[0]))
-		smap := make(map[string]string, len(tagKeys)+len(fieldKeys))
-		for i := 1; i < len(value); i++ {
-			k := columns[i]
-			v := value[i]
-			if tagMap[k] {
-				if v != nil {
-					smap[k] = v.(string)
-				} else {
-					smap[k] = ""
-				}
-			} else {
-				if i >= headerTotal {
-					if idx := strings.LastIndex(k, "_"); idx > -1 {
-						k = k[:idx]
-					}
-				}
-				if _, ok := fieldMap[k]; ok {
-					if v != nil {
-						smap[k] = fmt.Sprintf("%v", v)
-					} else {
-						smap[k] = ""
-					}
-				}
+func (me *MeasurementExport) whereClause(timeCond string) string {
+	if me.opts.Where == "" {
+		return "where " + timeCond
+	}
+	return fmt.Sprintf("where (%s) and %s", me.opts.Where, timeCond)
+}
+
+func (me *MeasurementExport) source() string {
+	return backend.Source(me.opts.RetentionPolicy.Name, me.meas)
+}
+
+func (me *MeasurementExport) writeSeries(series models.Rows) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("multi data type error: %v", r)
+		}
+	}()
+	if me.w == nil {
+		me.w = me.newWriter()
+	}
+	for _, row := range series {
+		if err = me.w.WriteSeries(row); err != nil {
+			return
+		}
+		if n := len(row.Values); n > 0 {
+			var t int64
+			if t, err = row.Values[n-1][0].(json.Number).Int64(); err != nil {
+				return
+			}
+			if !me.written || t > me.last {
+				me.last, me.written = t, true
 			}
 		}
-		for i := 1; i < headerTotal; i++ {
-			records = append(records, smap[columns[i]])
+	}
+	return
+}
+
+// timeRange returns the time range to export in nanoseconds, both included.
+func (me *MeasurementExport) timeRange() (start, end int64) {
+	start, end = me.opts.Start*1e9, me.opts.End*1e9
+	if me.incremental && me.since >= start {
+		since := me.since + 1
+		if ds := me.opts.Downsample; ds != nil {
+			// the interval of the watermark, possibly aggregated over part of its points by the previous export,
+			// is aggregated again as a whole, which overwrites the partial aggregate of the same time on import
+			since -= since % int64(ds.Interval)
+		}
+		if since > start {
+			start = since
 		}
-		csvw.Write(records)
 	}
-	csvw.Flush()
 	return
 }
+
+func (me *MeasurementExport) timeCond() string {
+	start, end := me.timeRange()
+	return fmt.Sprintf("time >= %d and time <= %d", start, end)
+}
+
+// Since exports only the data after the watermark, in nanoseconds, of a previous incremental export.
+func (me *MeasurementExport) Since(watermark int64) {
+	me.since, me.incremental = watermark, true
+}
+
+// Watermark returns the time in nanoseconds up to which the data has been exported, false if nothing written.
+// Timestamps of a lower precision cover the rest of their unit within the time range.
+// When downsampling, the last interval written is exported again by the next export, see timeRange.
+func (me *MeasurementExport) Watermark() (int64, bool) {
+	if !me.written {
+		return 0, false
+	}
+	unit := PrecisionUnit(me.opts.Precision)
+	_, end := me.timeRange()
+	watermark := me.last*unit + unit - 1
+	if watermark > end {
+		watermark = end
+	}
+	return watermark, true
+}
+
+// targetMeasurement returns the name of the measurement written, renamed by the mapping and the downsample.
+func (me *MeasurementExport) targetMeasurement() string {
+	meas := me.opts.Mapping.TargetMeasurement(me.meas)
+	if ds := me.opts.Downsample; ds != nil {
+		meas = ds.OutputMeasurement(meas)
+	}
+	return meas
+}
+
+func (me *MeasurementExport) newWriter() seriesWriter {
+	mapping := me.opts.Mapping
+	meas := me.targetMeasurement()
+	tagNames := mapping.TargetTagKeys(me.meas, me.tagKeys)
+	fieldNames := mapping.TargetFieldKeys(me.meas, me.fieldMap)
+	sc := newSeriesColumns(me.tagKeys, me.fieldMap, tagNames, fieldNames, me.companions)
+	base, limit := filepath.Join(me.opts.Dir, me.meas), me.opts.Limit
+	if me.opts.Merge {
+		// the merged file is rotated instead
+		limit = FileLimit{}
+	}
+	switch me.opts.Format {
+	case "csv":
+		return &csvWriter{
+			seriesColumns: sc,
+			f:             newRotateFile(base, FileExt("csv", me.opts.Compress), me.opts.Compress, limit, nil),
+			meas:          meas,
+			location:      me.opts.Location,
+			unit:          PrecisionUnit(me.opts.Precision),
+		}
+	case "jsonl":
+		return &jsonWriter{
+			seriesColumns: sc,
+			f:             newRotateFile(base, FileExt("jsonl", me.opts.Compress), me.opts.Compress, limit, nil),
+			meas:          meas,
+			location:      me.opts.Location,
+			unit:          PrecisionUnit(me.opts.Precision),
+		}
+	case "parquet":
+		return &parquetWriter{
+			seriesColumns: sc,
+			path:          base + FileExt("parquet", false),
+			precision:     me.opts.Precision,
+		}
+	case "prometheus":
+		return &promWriter{
+			seriesColumns: sc,
+			f:             newRotateFile(base, FileExt("prometheus", me.opts.Compress), me.opts.Compress, limit, nil),
+			meas:          meas,
+			unit:          PrecisionUnit(me.opts.Precision),
+			policy:        me.opts.NonNumeric,
+		}
+	case "graphite":
+		return &graphiteWriter{
+			seriesColumns: sc,
+			f:             newRotateFile(base, FileExt("graphite", me.opts.Compress), me.opts.Compress, limit, nil),
+			meas:          meas,
+			unit:          PrecisionUnit(me.opts.Precision),
+			template:      me.opts.GraphiteTemplate,
+		}
+	case "sqlite":
+		return &sqliteWriter{
+			seriesColumns: sc,
+			db:            me.opts.SQLite,
+			table:         meas,
+		}
+	case "arrow":
+		return &arrowWriter{
+			seriesColumns: sc,
+			path:          base + FileExt("arrow", false),
+			precision:     me.opts.Precision,
+		}
+	case "sql":
+		return &sqlWriter{
+			seriesColumns: sc,
+			f:             newRotateFile(base, FileExt("sql", me.opts.Compress), me.opts.Compress, limit, nil),
+			meas:          meas,
+			unit:          PrecisionUnit(me.opts.Precision),
+			batch:         me.opts.SQLBatch,
+			hypertable:    me.opts.Hypertable,
+		}
+	case "annotated-csv":
+		return &annotatedWriter{
+			seriesColumns: sc,
+			f:             newRotateFile(base, FileExt("annotated-csv", me.opts.Compress), me.opts.Compress, limit, nil),
+			base:          base,
+			meas:          meas,
+			unit:          PrecisionUnit(me.opts.Precision),
+		}
+	}
+	db, rp := mapping.TargetDatabase(me.opts.Database), mapping.TargetRetentionPolicy(me.opts.RetentionPolicy)
+	header := GetDMLHeader(db, rp, me.opts.Precision)
+	if me.opts.Merge {
+		header = GetContextHeader(db, rp)
+	}
+	return &lineWriter{
+		seriesColumns: sc,
+		f:             newRotateFile(base, FileExt("line", me.opts.Compress), me.opts.Compress, limit, func() string { return header }),
+		meas:          meas,
+	}
+}
@@ -0,0 +1,41 @@
+package tool
+
+import (
+	"testing"
+)
+
+func TestParseWhere(t *testing.T) {
+	tests := []struct {
+		where string
+		want  string
+		err   bool
+	}{
+		{where: "region='eu'", want: "region = 'eu'"},
+		{where: "region='eu' AND env!='dev'", want: "region = 'eu' AND env != 'dev'"},
+		{where: "(region='eu' OR region='us') AND usage > 0.5", want: "(region = 'eu' OR region = 'us') AND usage > 0.500"},
+		{where: "((host =~ /^web/))", want: "host =~ /^web/"},
+		{where: "region='eu' AMD env!='dev'", err: true},
+		{where: "region='eu' garbage here", err: true},
+		{where: "region='eu'; drop database x", err: true},
+		{where: "region='eu')", err: true},
+		{where: "region", err: true},
+		{where: "usage + 1", err: true},
+		{where: "mean(usage) > 1", err: true},
+		{where: "region='eu' AND", err: true},
+		{where: "", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseWhere(tt.where)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseWhere(%q) = %q, want error", tt.where, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseWhere(%q) error: %s", tt.where, err)
+		} else if got != tt.want {
+			t.Errorf("ParseWhere(%q) = %q, want %q", tt.where, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package tool
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/chengshiwen/influx-tool/util"
+	"github.com/klauspost/pgzip"
+)
+
+// formatExts maps the export formats to the extensions of their files.
+var formatExts = map[string]string{
+	"line":          ".txt",
+	"csv":           ".csv",
+	"jsonl":         ".jsonl",
+	"annotated-csv": ".csv",
+	"parquet":       ".parquet",
+	"arrow":         ".arrow",
+	"sql":           ".sql",
+	"sqlite":        ".db",
+	"prometheus":    ".prom",
+	"graphite":      ".graphite",
+}
+
+// binaryFormats are the export formats written as a whole by their own encoders instead of lines,
+// which can not be compressed, rotated or merged.
+var binaryFormats = util.NewSetFromSlice([]string{"parquet", "arrow", "sqlite"})
+
+// ValidFormat reports whether the export format is supported.
+func ValidFormat(format string) bool {
+	_, ok := formatExts[format]
+	return ok
+}
+
+// TextFormat reports whether export files in the format are lines of text.
+func TextFormat(format string) bool {
+	return !binaryFormats[format]
+}
+
+// FileExt returns the extension of export files in the format, with .gz appended if compressed.
+func FileExt(format string, compress bool) string {
+	ext := formatExts[format]
+	if compress {
+		ext += ".gz"
+	}
+	return ext
+}
+
+// OutputFile is a buffered export file, optionally compressed by a parallel gzip writer.
+// Compressed files may be concatenated as gzip members, which influx -import -compressed reads as a whole.
+type OutputFile struct {
+	f  *os.File
+	dw *digestWriter
+	zw *pgzip.Writer
+	bw *bufio.Writer
+}
+
+// digestWriter counts and hashes the bytes written into the file.
+type digestWriter struct {
+	w    io.Writer
+	hash hash.Hash
+	n    int64
+}
+
+func (dw *digestWriter) Write(p []byte) (int, error) {
+	n, err := dw.w.Write(p)
+	dw.hash.Write(p[:n])
+	dw.n += int64(n)
+	return n, err
+}
+
+// CreateOutputFile creates or truncates the file at path.
+func CreateOutputFile(path string, compress bool) (*OutputFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	of := &OutputFile{f: f, dw: &digestWriter{w: f, hash: sha256.New()}}
+	if compress {
+		of.zw = pgzip.NewWriter(of.dw)
+		of.bw = bufio.NewWriterSize(of.zw, WriterBufferSize)
+	} else {
+		of.bw = bufio.NewWriterSize(of.dw, WriterBufferSize)
+	}
+	return of, nil
+}
+
+func (of *OutputFile) Write(p []byte) (int, error) {
+	return of.bw.Write(p)
+}
+
+func (of *OutputFile) WriteString(s string) (int, error) {
+	return of.bw.WriteString(s)
+}
+
+// Close flushes the buffered and compressed data before closing the file.
+func (of *OutputFile) Close() error {
+	err := of.bw.Flush()
+	if of.zw != nil {
+		if cerr := of.zw.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if cerr := of.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Size returns the bytes written into the file, complete after closed.
+func (of *OutputFile) Size() int64 {
+	return of.dw.n
+}
+
+// Sum returns the hex encoded SHA-256 of the bytes written into the file, complete after closed.
+func (of *OutputFile) Sum() string {
+	return hex.EncodeToString(of.dw.hash.Sum(nil))
+}
+
+// FileLimit limits the size in bytes before compression and the lines of data of each export file, unlimited if 0.
+type FileLimit struct {
+	MaxSize  int64
+	MaxLines int64
+}
+
+// Enabled reports whether export files are rotated into numbered parts.
+func (fl FileLimit) Enabled() bool {
+	return fl.MaxSize > 0 || fl.MaxLines > 0
+}
+
+// PartPath returns the path of the numbered part of an export file, such as cpu.0001.txt, or the path itself if part is 0.
+func PartPath(base, ext string, part int) string {
+	if part == 0 {
+		return base + ext
+	}
+	return fmt.Sprintf("%s.%04d%s", base, part, ext)
+}
+
+// removeParts removes the export file base+ext and its numbered parts, except the paths to keep.
+func removeParts(base, ext string, keep util.Set) error {
+	dir, name := filepath.Split(base)
+	entries, err := ioutil.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		fn := entry.Name()
+		if fn != name+ext {
+			if !strings.HasPrefix(fn, name+".") || !strings.HasSuffix(fn, ext) {
+				continue
+			}
+			part := strings.TrimSuffix(strings.TrimPrefix(fn, name+"."), ext)
+			if _, err := strconv.Atoi(part); err != nil || len(part) < 4 {
+				continue
+			}
+		}
+		if keep[filepath.Join(dir, fn)] {
+			continue
+		}
+		if err = os.Remove(filepath.Join(dir, fn)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotateFile writes lines into an export file, rolling over to the next numbered part when the limit is reached.
+// The header is written at the beginning of every part, so that each part is self-contained.
+// Only a line of data rolls over to the next part, so that the lines before it stay in the same part.
+// The first part is created on the first write. The stat of each part is collected for the manifest.
+type rotateFile struct {
+	base     string
+	ext      string
+	compress bool
+	limit    FileLimit
+	header   func() string
+	tmp      bool // write parts into .tmp files until renamed by commit
+
+	stats []*FileStat
+	part  int
+	of    *OutputFile
+	size  int64
+	lines int64
+}
+
+func newRotateFile(base, ext string, compress bool, limit FileLimit, header func() string) *rotateFile {
+	return &rotateFile{base: base, ext: ext, compress: compress, limit: limit, header: header}
+}
+
+// WriteLine writes a line other than data, such as a comment, which must end with a newline.
+func (rf *rotateFile) WriteLine(line string) error {
+	return rf.write("", line)
+}
+
+// WritePoint writes a line of data at time t, which must end with a newline.
+func (rf *rotateFile) WritePoint(line string, t int64) error {
+	return rf.write("", line, t)
+}
+
+// WritePointAfter writes a line of data at time t after the lines leading it, such as the annotations of the table
+// it starts, which are left out if the line starts a part, whose header leads it instead.
+func (rf *rotateFile) WritePointAfter(lead, line string, t int64) error {
+	return rf.write(lead, line, t)
+}
+
+// WritePoints writes a line of data of points at times as a whole, such as a statement of multiple rows,
+// which must end with a newline.
+func (rf *rotateFile) WritePoints(line string, times []int64) error {
+	return rf.write("", line, times...)
+}
+
+func (rf *rotateFile) write(lead, line string, times ...int64) (err error) {
+	point := len(times) > 0
+	if point && rf.of != nil && rf.full(int64(len(lead)+len(line))) {
+		if err = rf.Close(); err != nil {
+			return
+		}
+	}
+	if rf.of == nil {
+		if err = rf.open(); err != nil {
+			return
+		}
+		lead = ""
+	}
+	n, err := rf.of.WriteString(lead + line)
+	rf.size += int64(n)
+	if point {
+		rf.lines++
+		stat := rf.stats[len(rf.stats)-1]
+		for _, t := range times {
+			stat.add(t)
+		}
+	}
+	return
+}
+
+func (rf *rotateFile) full(n int64) bool {
+	if rf.lines == 0 {
+		// at least one line per part, even if longer than the limit
+		return false
+	}
+	return (rf.limit.MaxSize > 0 && rf.size+n > rf.limit.MaxSize) || (rf.limit.MaxLines > 0 && rf.lines >= rf.limit.MaxLines)
+}
+
+func (rf *rotateFile) open() (err error) {
+	if rf.limit.Enabled() {
+		rf.part++
+	}
+	path := PartPath(rf.base, rf.ext, rf.part)
+	if rf.tmp {
+		path += ".tmp"
+	}
+	rf.of, err = CreateOutputFile(path, rf.compress)
+	if err != nil {
+		return
+	}
+	rf.stats = append(rf.stats, &FileStat{Path: PartPath(rf.base, rf.ext, rf.part)})
+	rf.size, rf.lines = 0, 0
+	if rf.header != nil {
+		if header := rf.header(); header != "" {
+			n, err := rf.of.WriteString(header + "\n")
+			rf.size += int64(n)
+			return err
+		}
+	}
+	return
+}
+
+// Close closes the current part, the next write creates a new part.
+func (rf *rotateFile) Close() error {
+	if rf.of == nil {
+		return nil
+	}
+	err := rf.of.Close()
+	stat := rf.stats[len(rf.stats)-1]
+	stat.Size, stat.SHA256 = rf.of.Size(), rf.of.Sum()
+	rf.of = nil
+	return err
+}
+
+// Stats returns the stats of the parts written, complete after closed.
+func (rf *rotateFile) Stats() []*FileStat {
+	return rf.stats
+}
+
+// commit renames the .tmp files of the parts written to their paths.
+func (rf *rotateFile) commit() error {
+	for _, stat := range rf.stats {
+		if err := os.Rename(stat.Path+".tmp", stat.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// discard removes the .tmp files of the parts written.
+func (rf *rotateFile) discard() {
+	rf.Close()
+	for _, stat := range rf.stats {
+		os.Remove(stat.Path + ".tmp")
+	}
+}
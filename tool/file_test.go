@@ -0,0 +1,148 @@
+package tool
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "influx-tool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func listDir(t *testing.T, dir string) []string {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestRemoveParts(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	files := []string{
+		"cpu.txt", "cpu.0001.txt", "cpu.0002.txt", "cpu.12345.txt",
+		"cpu.txt.gz", "cpu.0001.txt.gz", "cpu.csv", "cpu.01.txt", "cpu.abcd.txt", "cpu.disk.txt",
+		"cpu.0001.spool", "mem.txt", "mem.0001.txt", "cpux.0001.txt",
+	}
+	for _, fn := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, fn), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := removeParts(filepath.Join(dir, "cpu"), ".txt", nil); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"cpu.0001.spool", "cpu.0001.txt.gz", "cpu.01.txt", "cpu.abcd.txt", "cpu.csv", "cpu.disk.txt", "cpu.txt.gz",
+		"cpux.0001.txt", "mem.0001.txt", "mem.txt",
+	}
+	if got := listDir(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("files left = %v, want %v", got, want)
+	}
+}
+
+func TestPartPath(t *testing.T) {
+	if got := PartPath("export/cpu", ".txt", 0); got != "export/cpu.txt" {
+		t.Errorf("PartPath part 0 = %s", got)
+	}
+	if got := PartPath("export/cpu", ".txt.gz", 12); got != "export/cpu.0012.txt.gz" {
+		t.Errorf("PartPath part 12 = %s", got)
+	}
+}
+
+func TestRotateFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit FileLimit
+		parts []string
+	}{
+		{
+			name:  "unlimited",
+			parts: []string{"h\n# a\n1\n2\n3\n# b\n4\n5\n"},
+		},
+		{
+			name:  "max lines",
+			limit: FileLimit{MaxLines: 2},
+			parts: []string{"h\n# a\n1\n2\n", "h\n3\n# b\n4\n", "h\n5\n"},
+		},
+		{
+			// the header and comments count in size, but never roll over by themselves
+			name:  "max size",
+			limit: FileLimit{MaxSize: 8},
+			parts: []string{"h\n# a\n1\n", "h\n2\n3\n# b\n", "h\n4\n5\n"},
+		},
+		{
+			// a line longer than the limit is written into a part of its own
+			name:  "line over size",
+			limit: FileLimit{MaxSize: 1},
+			parts: []string{"h\n# a\n1\n", "h\n2\n", "h\n3\n# b\n", "h\n4\n", "h\n5\n"},
+		},
+	}
+	for _, tt := range tests {
+		dir := tempDir(t)
+		rf := newRotateFile(filepath.Join(dir, "cpu"), ".txt", false, tt.limit, func() string { return "h" })
+		writes := []struct {
+			line string
+			t    int64
+		}{{"# a\n", -1}, {"1\n", 1}, {"2\n", 2}, {"3\n", 3}, {"# b\n", -1}, {"4\n", 4}, {"5\n", 5}}
+		for _, w := range writes {
+			var err error
+			if w.t < 0 {
+				err = rf.WriteLine(w.line)
+			} else {
+				err = rf.WritePoint(w.line, w.t)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := rf.Close(); err != nil {
+			t.Fatal(err)
+		}
+		stats := rf.Stats()
+		if len(stats) != len(tt.parts) {
+			t.Errorf("%s: %d parts, want %d", tt.name, len(stats), len(tt.parts))
+			os.RemoveAll(dir)
+			continue
+		}
+		points := int64(0)
+		for i, stat := range stats {
+			part := 0
+			if tt.limit.Enabled() {
+				part = i + 1
+			}
+			if stat.Path != PartPath(filepath.Join(dir, "cpu"), ".txt", part) {
+				t.Errorf("%s: part %d path %s", tt.name, i, stat.Path)
+			}
+			data, err := ioutil.ReadFile(stat.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.parts[i] {
+				t.Errorf("%s: part %d = %q, want %q", tt.name, i, data, tt.parts[i])
+			}
+			if stat.Size != int64(len(data)) {
+				t.Errorf("%s: part %d size %d, want %d", tt.name, i, stat.Size, len(data))
+			}
+			points += stat.Points
+		}
+		if points != 5 || stats[0].MinTime != 1 || stats[len(stats)-1].MaxTime != 5 {
+			t.Errorf("%s: points %d, min time %d, max time %d", tt.name, points, stats[0].MinTime, stats[len(stats)-1].MaxTime)
+		}
+		os.RemoveAll(dir)
+	}
+}
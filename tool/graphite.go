@@ -0,0 +1,104 @@
+package tool
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/influxdata/influxdb1-client/models"
+)
+
+// GraphiteTemplate builds the metric path of a field value from the nodes split by '.',
+// where measurement, field and tag:<key> are replaced by the measurement, field key and tag value,
+// and other nodes are kept as they are. A node of a tag missing in the point is left out.
+type GraphiteTemplate []string
+
+// ParseGraphiteTemplate parses a template such as measurement.tag:host.field.
+func ParseGraphiteTemplate(s string) (GraphiteTemplate, error) {
+	nodes := strings.Split(s, ".")
+	field := false
+	for _, node := range nodes {
+		if node == "" || node == "tag:" {
+			return nil, fmt.Errorf("empty node in template %s", s)
+		}
+		if node == "field" {
+			field = true
+		}
+	}
+	if !field {
+		return nil, errors.New("field node required")
+	}
+	return GraphiteTemplate(nodes), nil
+}
+
+// Path returns the metric path of the field value of the point with the tags, with each node sanitized.
+func (gt GraphiteTemplate) Path(meas, field string, tags map[string]string) string {
+	nodes := make([]string, 0, len(gt))
+	for _, node := range gt {
+		switch {
+		case node == "measurement":
+			node = meas
+		case node == "field":
+			node = field
+		case strings.HasPrefix(node, "tag:"):
+			v, ok := tags[node[len("tag:"):]]
+			if !ok || v == "" {
+				continue
+			}
+			node = v
+		}
+		nodes = append(nodes, graphiteNode(node))
+	}
+	return strings.Join(nodes, ".")
+}
+
+// graphiteNode sanitizes a node of the metric path, replacing characters other than letters, digits, '_' and '-'
+// with underscores, including dots which split the nodes.
+func graphiteNode(node string) string {
+	b := []byte(node)
+	for i, c := range b {
+		if !(c == '_' || c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// graphiteWriter writes the points of a measurement in the graphite plaintext protocol, as a line of
+// path, value and timestamp in seconds for each numeric field value, where string and boolean fields are skipped.
+type graphiteWriter struct {
+	*seriesColumns
+	f        *rotateFile
+	meas     string
+	unit     int64
+	template GraphiteTemplate
+}
+
+func (gw *graphiteWriter) WriteSeries(row *models.Row) error {
+	return gw.decode(row, func(p *seriesPoint) error {
+		ts := strconv.FormatInt(p.time*gw.unit/1e9, 10)
+		tags := make(map[string]string, len(p.tags))
+		for _, tag := range p.tags {
+			tags[gw.tagNames[tag[0]]] = tag[1]
+		}
+		for _, field := range p.fields {
+			if field.vtype != "float" && field.vtype != "integer" {
+				continue
+			}
+			path := gw.template.Path(gw.meas, gw.fieldNames[field.key], tags)
+			if err := gw.f.WritePoint(fmt.Sprintf("%s %s %s\n", path, formatValue(field.value), ts), p.time); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (gw *graphiteWriter) Close() error {
+	return gw.f.Close()
+}
+
+func (gw *graphiteWriter) Stats() []*FileStat {
+	return gw.f.Stats()
+}
@@ -0,0 +1,77 @@
+package tool
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGraphiteTemplate(t *testing.T) {
+	tests := []struct {
+		s    string
+		want GraphiteTemplate
+		err  bool
+	}{
+		{s: "measurement.field", want: GraphiteTemplate{"measurement", "field"}},
+		{s: "servers.tag:host.measurement.field", want: GraphiteTemplate{"servers", "tag:host", "measurement", "field"}},
+		{s: "field", want: GraphiteTemplate{"field"}},
+		{s: "measurement.tag:host", err: true},
+		{s: "measurement..field", err: true},
+		{s: "measurement.field.", err: true},
+		{s: "tag:.field", err: true},
+		{s: "", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseGraphiteTemplate(tt.s)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseGraphiteTemplate(%q) = %v, want error", tt.s, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseGraphiteTemplate(%q) error: %s", tt.s, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseGraphiteTemplate(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestGraphiteTemplatePath(t *testing.T) {
+	tags := map[string]string{"host": "web.01", "region": "", "path": "/var/log"}
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{template: "measurement.field", want: "disk_io.read_bytes"},
+		{template: "servers.tag:host.measurement.field", want: "servers.web_01.disk_io.read_bytes"},
+		// nodes of tags missing or empty are left out
+		{template: "tag:dc.tag:region.tag:host.field", want: "web_01.read_bytes"},
+		{template: "tag:path.field", want: "_var_log.read_bytes"},
+	}
+	for _, tt := range tests {
+		gt, err := ParseGraphiteTemplate(tt.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := gt.Path("disk io", "read.bytes", tags); got != tt.want {
+			t.Errorf("Path of %s = %s, want %s", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestGraphiteNode(t *testing.T) {
+	tests := []struct {
+		node string
+		want string
+	}{
+		{node: "cpu_usage-1", want: "cpu_usage-1"},
+		{node: "a.b c", want: "a_b_c"},
+		{node: "x=1,y=2", want: "x_1_y_2"},
+		{node: "温度", want: "______"},
+	}
+	for _, tt := range tests {
+		if got := graphiteNode(tt.node); got != tt.want {
+			t.Errorf("graphiteNode(%q) = %s, want %s", tt.node, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package tool
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ManifestFile is the name of the manifest file in the export directory.
+const ManifestFile = "manifest.json"
+
+// FileStat describes an export file written, with the min and max timestamps of its points in the precision.
+type FileStat struct {
+	Path    string `json:"path"`
+	Points  int64  `json:"points"`
+	MinTime int64  `json:"min_time"`
+	MaxTime int64  `json:"max_time"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+}
+
+// add counts a point written at t into the stat.
+func (s *FileStat) add(t int64) {
+	if s.Points == 0 || t < s.MinTime {
+		s.MinTime = t
+	}
+	if s.Points == 0 || t > s.MaxTime {
+		s.MaxTime = t
+	}
+	s.Points++
+}
+
+// ManifestEntry describes an export file of a measurement, or of all measurements merged without database,
+// retention policy and measurement. The path is relative to the export directory.
+type ManifestEntry struct {
+	Database        string `json:"database,omitempty"`
+	RetentionPolicy string `json:"retention_policy,omitempty"`
+	Measurement     string `json:"measurement,omitempty"`
+	Format          string `json:"format"`
+	Precision       string `json:"precision"`
+	Compressed      bool   `json:"compressed"`
+	FileStat
+}
+
+// Manifest lists the export files in the export directory, written by the version of the tool.
+type Manifest struct {
+	Version   string           `json:"version"`
+	GitCommit string           `json:"git_commit"`
+	Time      time.Time        `json:"time"`
+	Files     []*ManifestEntry `json:"files"`
+}
+
+// Add adds the entries of the files written in dir, whose paths are made relative to dir.
+func (m *Manifest) Add(dir string, entry ManifestEntry, stats []*FileStat) error {
+	for _, stat := range stats {
+		e := entry
+		e.FileStat = *stat
+		path, err := filepath.Rel(dir, stat.Path)
+		if err != nil {
+			return err
+		}
+		e.Path = filepath.ToSlash(path)
+		m.Files = append(m.Files, &e)
+	}
+	return nil
+}
+
+// WriteManifest writes the manifest file into dir.
+func WriteManifest(dir string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, ManifestFile+".tmp")
+	if err = ioutil.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, ManifestFile))
+}
+
+// LoadManifest loads the manifest file in dir.
+func LoadManifest(dir string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// VerifyFile checks the size and SHA-256 of the export file of the entry in dir.
+func VerifyFile(dir string, entry *ManifestEntry) error {
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(entry.Path)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	n, err := io.Copy(hash, f)
+	if err != nil {
+		return err
+	}
+	if n != entry.Size {
+		return fmt.Errorf("size mismatch, %d bytes expected but got %d", entry.Size, n)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != entry.SHA256 {
+		return fmt.Errorf("sha256 mismatch, %s expected but got %s", entry.SHA256, sum)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package tool
+
+import (
+	"io/ioutil"
+
+	"github.com/chengshiwen/influx-tool/backend"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// Mapping renames databases, retention policies, measurements, tag keys and field keys while exporting.
+// Tag keys and field keys are given as 'key' for all measurements or 'measurement:key' for one measurement,
+// where the measurement is the source name. A nil mapping renames nothing.
+//
+//	{
+//	  "databases": {"telegraf_old": "telegraf"},
+//	  "retention_policies": {"autogen": "rp_1y"},
+//	  "measurements": {"cpu_v1": "cpu"},
+//	  "tags": {"hostname": "host"},
+//	  "fields": {"cpu_v1:val": "value"}
+//	}
+type Mapping struct {
+	Databases         map[string]string `json:"databases"`
+	RetentionPolicies map[string]string `json:"retention_policies"`
+	Measurements      map[string]string `json:"measurements"`
+	Tags              map[string]string `json:"tags"`
+	Fields            map[string]string `json:"fields"`
+}
+
+func LoadMapping(path string) (m *Mapping, err error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	m = &Mapping{}
+	err = jsoniter.Unmarshal(b, m)
+	return
+}
+
+func rename(names map[string]string, name string) string {
+	if target, ok := names[name]; ok && target != "" {
+		return target
+	}
+	return name
+}
+
+func renameKey(names map[string]string, meas, key string) string {
+	if target, ok := names[meas+":"+key]; ok && target != "" {
+		return target
+	}
+	return rename(names, key)
+}
+
+func (m *Mapping) TargetDatabase(db string) string {
+	if m == nil {
+		return db
+	}
+	return rename(m.Databases, db)
+}
+
+// TargetRetentionPolicy returns a copy of the retention policy with the target name.
+func (m *Mapping) TargetRetentionPolicy(rp *backend.RetentionPolicy) *backend.RetentionPolicy {
+	if m == nil {
+		return rp
+	}
+	target := *rp
+	target.Name = rename(m.RetentionPolicies, rp.Name)
+	return &target
+}
+
+// TargetRetentionPolicies returns copies of the retention policies with the target names.
+func (m *Mapping) TargetRetentionPolicies(rps []*backend.RetentionPolicy) []*backend.RetentionPolicy {
+	targets := make([]*backend.RetentionPolicy, 0, len(rps))
+	for _, rp := range rps {
+		targets = append(targets, m.TargetRetentionPolicy(rp))
+	}
+	return targets
+}
+
+func (m *Mapping) TargetMeasurement(meas string) string {
+	if m == nil {
+		return meas
+	}
+	return rename(m.Measurements, meas)
+}
+
+// TargetTagKeys returns the target names of the tag keys of the measurement.
+func (m *Mapping) TargetTagKeys(meas string, tagKeys []string) map[string]string {
+	names := make(map[string]string, len(tagKeys))
+	for _, key := range tagKeys {
+		if m == nil {
+			names[key] = key
+		} else {
+			names[key] = renameKey(m.Tags, meas, key)
+		}
+	}
+	return names
+}
+
+// TargetFieldKeys returns the target names of the field keys of the measurement.
+func (m *Mapping) TargetFieldKeys(meas string, fieldMap map[string]string) map[string]string {
+	names := make(map[string]string, len(fieldMap))
+	for key := range fieldMap {
+		if m == nil {
+			names[key] = key
+		} else {
+			names[key] = renameKey(m.Fields, meas, key)
+		}
+	}
+	return names
+}
@@ -0,0 +1,331 @@
+package tool
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/util"
+	"github.com/klauspost/pgzip"
+)
+
+// MergeFiles merges the export files of measurements at paths, in the given order, into the merged file
+// in the export directory of opts, rotated if limited, and returns the stats of the files merged.
+// The merged file is written into .tmp files and renamed when complete,
+// then the files of measurements are removed; they are left untouched if failed.
+// Line protocol files are concatenated after the header, where every part of the merged file starts with the header
+// followed by the context of the data it continues with. Csv files are merged under the union of their headers.
+// Annotated csv files are concatenated table by table, sql dumps statement by statement,
+// and prometheus and graphite files sample by sample.
+func MergeFiles(paths []string, header string, opts *ExportOptions) (stats []*FileStat, err error) {
+	base, ext := filepath.Join(opts.Dir, "merge"), FileExt(opts.Format, opts.Compress)
+	// parts left by a previous merge are replaced, but not the files of a measurement named as the merged file
+	if err = removeParts(base, ext, util.NewSetFromSlice(paths)); err != nil {
+		return
+	}
+	var rf *rotateFile
+	switch opts.Format {
+	case "csv":
+		rf, err = mergeCsvFiles(paths, base, ext, opts)
+	case "jsonl":
+		rf, err = mergeJSONFiles(paths, base, ext, opts)
+	case "annotated-csv":
+		rf, err = mergeAnnotatedFiles(paths, base, ext, opts)
+	case "sql":
+		rf, err = mergeSQLFiles(paths, base, ext, opts)
+	case "prometheus":
+		// timestamps of samples are in milliseconds
+		rf, err = mergeTimestampedFiles(paths, base, ext, opts, 1e6)
+	case "graphite":
+		// timestamps are in seconds
+		rf, err = mergeTimestampedFiles(paths, base, ext, opts, 1e9)
+	default:
+		rf, err = mergeLineFiles(paths, base, ext, header, opts)
+	}
+	if err != nil {
+		rf.discard()
+		return
+	}
+	if err = rf.Close(); err != nil {
+		rf.discard()
+		return
+	}
+	if err = rf.commit(); err != nil {
+		return
+	}
+	stats = rf.Stats()
+	merged := util.NewSet()
+	for _, stat := range stats {
+		merged.Add(stat.Path)
+	}
+	for _, path := range paths {
+		// a measurement may be named as the merged file
+		if merged[path] {
+			continue
+		}
+		if err = os.Remove(path); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// openMergeFile opens the merged file rotated as limited, whose parts are written into .tmp files until committed.
+// It is opened before reading any file, so that the merged file is written even if there is no data.
+func openMergeFile(base, ext string, opts *ExportOptions, header func() string) (*rotateFile, error) {
+	rf := newRotateFile(base, ext, opts.Compress, opts.Limit, header)
+	rf.tmp = true
+	return rf, rf.open()
+}
+
+// mergeTimestampedFiles concatenates files whose lines are data ending with the timestamp in units of scale
+// nanoseconds, such as the samples of prometheus in milliseconds and the data of graphite in seconds.
+func mergeTimestampedFiles(paths []string, base, ext string, opts *ExportOptions, scale int64) (*rotateFile, error) {
+	rf, err := openMergeFile(base, ext, opts, nil)
+	if err != nil {
+		return rf, err
+	}
+	unit := PrecisionUnit(opts.Precision)
+	for _, path := range paths {
+		err = readLines(path, opts.Compress, func(line string) error {
+			data := strings.TrimSuffix(line, "\n")
+			ts, err := strconv.ParseInt(data[strings.LastIndexByte(data, ' ')+1:], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid timestamp of line: %s", line)
+			}
+			return rf.WritePoint(line, ts*scale/unit)
+		})
+		if err != nil {
+			return rf, err
+		}
+	}
+	return rf, nil
+}
+
+func mergeLineFiles(paths []string, base, ext, header string, opts *ExportOptions) (*rotateFile, error) {
+	var database, retentionPolicy string
+	rf, err := openMergeFile(base, ext, opts, func() string {
+		lines := []string{header}
+		if database != "" {
+			lines = append(lines, database)
+		}
+		if retentionPolicy != "" {
+			lines = append(lines, retentionPolicy)
+		}
+		return strings.Join(lines, "\n")
+	})
+	if err != nil {
+		return rf, err
+	}
+	for _, path := range paths {
+		err := readLines(path, opts.Compress, func(line string) error {
+			if err := writeLine(rf, line); err != nil {
+				return err
+			}
+			if strings.HasPrefix(line, "# CONTEXT-DATABASE:") {
+				database = strings.TrimSuffix(line, "\n")
+			} else if strings.HasPrefix(line, "# CONTEXT-RETENTION-POLICY:") {
+				retentionPolicy = strings.TrimSuffix(line, "\n")
+			}
+			return nil
+		})
+		if err != nil {
+			return rf, err
+		}
+	}
+	return rf, nil
+}
+
+func mergeJSONFiles(paths []string, base, ext string, opts *ExportOptions) (*rotateFile, error) {
+	rf, err := openMergeFile(base, ext, opts, nil)
+	if err != nil {
+		return rf, err
+	}
+	for _, path := range paths {
+		err := readLines(path, opts.Compress, func(line string) error {
+			point := &struct {
+				Time json.RawMessage `json:"time"`
+			}{}
+			if err := json.Unmarshal([]byte(line), point); err != nil {
+				return err
+			}
+			t, err := parseTime(strings.Trim(string(point.Time), "\""), opts.Precision)
+			if err != nil {
+				return err
+			}
+			return rf.WritePoint(line, t)
+		})
+		if err != nil {
+			return rf, err
+		}
+	}
+	return rf, nil
+}
+
+func mergeCsvFiles(paths []string, base, ext string, opts *ExportOptions) (*rotateFile, error) {
+	// the union of headers, in order of appearance
+	headers := make([]string, 0)
+	index := make(map[string]int)
+	for _, path := range paths {
+		err := readFile(path, opts.Compress, func(r io.Reader) error {
+			record, err := newCsvReader(r).Read()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			for _, h := range record {
+				if _, ok := index[h]; !ok {
+					index[h] = len(headers)
+					headers = append(headers, h)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return newRotateFile(base, ext, opts.Compress, opts.Limit, nil), err
+		}
+	}
+
+	var buf bytes.Buffer
+	csvw := csv.NewWriter(&buf)
+	format := func(record []string) (string, error) {
+		buf.Reset()
+		csvw.Write(record)
+		csvw.Flush()
+		return buf.String(), csvw.Error()
+	}
+	line, err := format(headers)
+	if err != nil {
+		return newRotateFile(base, ext, opts.Compress, opts.Limit, nil), err
+	}
+	names := "\xEF\xBB\xBF" + strings.TrimSuffix(line, "\n")
+	rf, err := openMergeFile(base, ext, opts, func() string { return names })
+	if err != nil {
+		return rf, err
+	}
+	for _, path := range paths {
+		err = readFile(path, opts.Compress, func(r io.Reader) error {
+			cr := newCsvReader(r)
+			fields, err := cr.Read()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			fields = append([]string{}, fields...)
+			for {
+				record, err := cr.Read()
+				if err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+				merged := make([]string, len(headers))
+				for i, v := range record {
+					merged[index[fields[i]]] = v
+				}
+				t, err := parseTime(merged[1], opts.Precision)
+				if err != nil {
+					return err
+				}
+				line, err := format(merged)
+				if err != nil {
+					return err
+				}
+				if err = rf.WritePoint(line, t); err != nil {
+					return err
+				}
+			}
+		})
+		if err != nil {
+			return rf, err
+		}
+	}
+	return rf, nil
+}
+
+// writeLine writes a line of line protocol, as a point unless a comment or empty line.
+func writeLine(rf *rotateFile, line string) error {
+	if strings.HasPrefix(line, "#") || line == "\n" {
+		return rf.WriteLine(line)
+	}
+	data := strings.TrimSuffix(line, "\n")
+	t, err := strconv.ParseInt(data[strings.LastIndexByte(data, ' ')+1:], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp of line: %s", line)
+	}
+	return rf.WritePoint(line, t)
+}
+
+// parseTime parses a timestamp written as an epoch or in RFC3339, into the precision.
+func parseTime(value, precision string) (int64, error) {
+	if t, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return 0, err
+	}
+	return t.UnixNano() / PrecisionUnit(precision), nil
+}
+
+// newCsvReader reads csv written by csvWriter, skipping the byte order mark.
+func newCsvReader(r io.Reader) *csv.Reader {
+	br := bufio.NewReaderSize(r, WriterBufferSize)
+	if bom, err := br.Peek(3); err == nil && string(bom) == "\xEF\xBB\xBF" {
+		br.Discard(3)
+	}
+	cr := csv.NewReader(br)
+	cr.ReuseRecord = true
+	return cr
+}
+
+// readLines reads the export file at path line by line, each ending with a newline.
+func readLines(path string, compress bool, fn func(line string) error) error {
+	return readFile(path, compress, func(r io.Reader) error {
+		br := bufio.NewReaderSize(r, WriterBufferSize)
+		for {
+			line, err := br.ReadString('\n')
+			if line != "" {
+				if !strings.HasSuffix(line, "\n") {
+					line += "\n"
+				}
+				if ferr := fn(line); ferr != nil {
+					return ferr
+				}
+			}
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// readFile opens the export file at path, decompressed if compressed, and passes it to fn.
+func readFile(path string, compress bool, fn func(r io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if !compress {
+		return fn(f)
+	}
+	zr, err := pgzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	return fn(zr)
+}
@@ -0,0 +1,171 @@
+package tool
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files [][2]string) []string {
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		path := filepath.Join(dir, file[0])
+		if err := ioutil.WriteFile(path, []byte(file[1]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func readStats(t *testing.T, stats []*FileStat) []string {
+	parts := make([]string, 0, len(stats))
+	for _, stat := range stats {
+		data, err := ioutil.ReadFile(stat.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, string(data))
+	}
+	return parts
+}
+
+func TestMergeLineFiles(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	paths := writeFiles(t, dir, [][2]string{
+		{"cpu.txt", "# CONTEXT-DATABASE:db1\n# CONTEXT-RETENTION-POLICY:autogen\ncpu v=1 1\ncpu v=2 2\n"},
+		{"mem.txt", "# CONTEXT-DATABASE:db2\n# CONTEXT-RETENTION-POLICY:rp\nmem v=3 3\nmem v=4 4"},
+	})
+	// a part left by a previous merge of more data
+	writeFiles(t, dir, [][2]string{{"merge.0004.txt", "# DML\ncpu v=0 0\n"}})
+	opts := &ExportOptions{Dir: dir, Format: "line", Precision: "ns", Limit: FileLimit{MaxLines: 3}}
+	stats, err := MergeFiles(paths, "# DDL\nCREATE DATABASE db1\nCREATE DATABASE db2\n# DML", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every part starts with the header followed by the context of the data it continues with
+	want := []string{
+		"# DDL\nCREATE DATABASE db1\nCREATE DATABASE db2\n# DML\n" +
+			"# CONTEXT-DATABASE:db1\n# CONTEXT-RETENTION-POLICY:autogen\ncpu v=1 1\ncpu v=2 2\n" +
+			"# CONTEXT-DATABASE:db2\n# CONTEXT-RETENTION-POLICY:rp\nmem v=3 3\n",
+		"# DDL\nCREATE DATABASE db1\nCREATE DATABASE db2\n# DML\n" +
+			"# CONTEXT-DATABASE:db2\n# CONTEXT-RETENTION-POLICY:rp\nmem v=4 4\n",
+	}
+	if got := readStats(t, stats); !reflect.DeepEqual(got, want) {
+		t.Errorf("merged parts = %q, want %q", got, want)
+	}
+	if stats[0].Points != 3 || stats[0].MinTime != 1 || stats[0].MaxTime != 3 || stats[1].Points != 1 {
+		t.Errorf("merged stats = %+v, %+v", stats[0], stats[1])
+	}
+	if got := listDir(t, dir); !reflect.DeepEqual(got, []string{"merge.0001.txt", "merge.0002.txt"}) {
+		t.Errorf("files left = %v", got)
+	}
+}
+
+func TestMergeLineFilesFailed(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	paths := writeFiles(t, dir, [][2]string{
+		{"cpu.txt", "cpu v=1 1\n"},
+		{"mem.txt", "mem v=2 two\n"},
+	})
+	opts := &ExportOptions{Dir: dir, Format: "line", Precision: "ns"}
+	if _, err := MergeFiles(paths, "# DML", opts); err == nil {
+		t.Fatal("merged with an invalid timestamp")
+	}
+	// the files of measurements are left untouched, without any merged or temporary file
+	if got := listDir(t, dir); !reflect.DeepEqual(got, []string{"cpu.txt", "mem.txt"}) {
+		t.Errorf("files left = %v", got)
+	}
+}
+
+func TestMergeCsvFiles(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	paths := writeFiles(t, dir, [][2]string{
+		{"cpu.csv", "\xEF\xBB\xBFname,time,host,usage\ncpu,1,h0,0.5\ncpu,2,\"h,1\",1.5\n"},
+		{"disk.csv", "\xEF\xBB\xBFname,time,path,usage\ndisk,2020-09-13T12:26:43Z,/,3\n"},
+	})
+	opts := &ExportOptions{Dir: dir, Format: "csv", Precision: "s"}
+	stats, err := MergeFiles(paths, "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// merged under the union of the headers
+	want := []string{"\xEF\xBB\xBFname,time,host,usage,path\ncpu,1,h0,0.5,\ncpu,2,\"h,1\",1.5,\ndisk,2020-09-13T12:26:43Z,,3,/\n"}
+	if got := readStats(t, stats); !reflect.DeepEqual(got, want) {
+		t.Errorf("merged parts = %q, want %q", got, want)
+	}
+	if stats[0].Points != 3 || stats[0].MinTime != 1 || stats[0].MaxTime != 1600000003 {
+		t.Errorf("merged stats = %+v", stats[0])
+	}
+}
+
+func TestMergeCompressedFiles(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	paths := make([]string, 0)
+	for i, lines := range [][]string{{"cpu v=1 1\n", "cpu v=2 2\n"}, {"mem v=3 3\n"}} {
+		rf := newRotateFile(filepath.Join(dir, []string{"cpu", "mem"}[i]), FileExt("line", true), true, FileLimit{}, nil)
+		for j, line := range lines {
+			if err := rf.WritePoint(line, int64(j)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := rf.Close(); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, rf.Stats()[0].Path)
+	}
+	opts := &ExportOptions{Dir: dir, Format: "line", Precision: "ns", Compress: true}
+	stats, err := MergeFiles(paths, "# DML", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := make([]string, 0)
+	if err = readLines(stats[0].Path, true, func(line string) error {
+		lines = append(lines, line)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"# DML\n", "cpu v=1 1\n", "cpu v=2 2\n", "mem v=3 3\n"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("merged lines = %q, want %q", lines, want)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		value     string
+		precision string
+		want      int64
+		err       bool
+	}{
+		{value: "1600000000000000000", precision: "ns", want: 1600000000000000000},
+		{value: "1600000000", precision: "s", want: 1600000000},
+		{value: "-1", precision: "ns", want: -1},
+		{value: "2020-09-13T12:26:40Z", precision: "ns", want: 1600000000000000000},
+		{value: "2020-09-13T12:26:40.5Z", precision: "ms", want: 1600000000500},
+		{value: "2020-09-13T20:26:40+08:00", precision: "s", want: 1600000000},
+		{value: "2020-09-13T12:26:40Z", precision: "h", want: 444444},
+		{value: "2020-09-13 12:26:40", precision: "ns", err: true},
+		{value: "", precision: "ns", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTime(tt.value, tt.precision)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseTime(%q) = %d, want error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTime(%q) error: %s", tt.value, err)
+		} else if got != tt.want {
+			t.Errorf("parseTime(%q, %s) = %d, want %d", tt.value, tt.precision, got, tt.want)
+		}
+	}
+}
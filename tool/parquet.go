@@ -0,0 +1,153 @@
+package tool
+
+import (
+	"fmt"
+
+	"github.com/influxdata/influxdb1-client/models"
+	"github.com/xitongsys/parquet-go/marshal"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// ParquetRowGroupSize is the estimated size of data buffered before flushed as a row group into a parquet file.
+const ParquetRowGroupSize = 64 << 20
+
+// parquetWriter writes the points of a measurement into a parquet file, with a timestamp column named time,
+// dictionary encoded string columns of tags and columns of fields typed by the field types.
+// The file is created on the first write, with the schema of the columns.
+type parquetWriter struct {
+	*seriesColumns
+	path      string
+	precision string
+
+	scale int64
+	of    *OutputFile
+	pw    *writer.ParquetWriter
+	stat  *FileStat
+}
+
+func (pqw *parquetWriter) WriteSeries(row *models.Row) error {
+	return pqw.decode(row, func(p *seriesPoint) error {
+		if pqw.pw == nil {
+			if err := pqw.open(); err != nil {
+				return err
+			}
+		}
+		record := pqw.record(p)
+		record[0] = p.time * pqw.scale
+		if err := pqw.pw.Write(record); err != nil {
+			return err
+		}
+		pqw.stat.add(p.time)
+		return nil
+	})
+}
+
+func (pqw *parquetWriter) open() (err error) {
+	root := parquet.NewSchemaElement()
+	root.Name = "schema"
+	root.RepetitionType = parquet.FieldRepetitionTypePtr(parquet.FieldRepetitionType_REQUIRED)
+	children := int32(1 + len(pqw.tagKeys) + len(pqw.fieldKeys))
+	root.NumChildren = &children
+	timestamp := &parquet.TimestampType{IsAdjustedToUTC: true, Unit: parquet.NewTimeUnit()}
+	pqw.scale = 1
+	switch pqw.precision {
+	case "ns":
+		timestamp.Unit.NANOS = parquet.NewNanoSeconds()
+	case "us":
+		timestamp.Unit.MICROS = parquet.NewMicroSeconds()
+	case "ms":
+		timestamp.Unit.MILLIS = parquet.NewMilliSeconds()
+	default:
+		// timestamps in seconds, minutes or hours are stored in milliseconds
+		timestamp.Unit.MILLIS = parquet.NewMilliSeconds()
+		pqw.scale = PrecisionUnit(pqw.precision) / 1e6
+	}
+	logical := parquet.NewLogicalType()
+	logical.TIMESTAMP = timestamp
+	elements := []*parquet.SchemaElement{root, parquetElement("time", parquet.Type_INT64, logical)}
+	elements[1].RepetitionType = parquet.FieldRepetitionTypePtr(parquet.FieldRepetitionType_REQUIRED)
+	for _, k := range pqw.tagKeys {
+		elements = append(elements, parquetElement(pqw.tagNames[k], parquet.Type_BYTE_ARRAY, parquetString()))
+	}
+	for _, k := range pqw.fieldKeys {
+		switch pqw.fieldMap[k] {
+		case "float":
+			elements = append(elements, parquetElement(pqw.fieldNames[k], parquet.Type_DOUBLE, nil))
+		case "integer":
+			elements = append(elements, parquetElement(pqw.fieldNames[k], parquet.Type_INT64, nil))
+		case "boolean":
+			elements = append(elements, parquetElement(pqw.fieldNames[k], parquet.Type_BOOLEAN, nil))
+		default:
+			elements = append(elements, parquetElement(pqw.fieldNames[k], parquet.Type_BYTE_ARRAY, parquetString()))
+		}
+	}
+
+	if pqw.of, err = CreateOutputFile(pqw.path, false); err != nil {
+		return
+	}
+	pqw.stat = &FileStat{Path: pqw.path}
+	if pqw.pw, err = writer.NewParquetWriterFromWriter(pqw.of, elements, 1); err != nil {
+		return
+	}
+	// columns are named by the schema elements, so names are checked not to collide as identifiers
+	columns := make(map[string]string, len(elements))
+	for _, info := range pqw.pw.SchemaHandler.Infos[1:] {
+		if name, ok := columns[info.InName]; ok {
+			return fmt.Errorf("parquet columns %s and %s collide", name, info.ExName)
+		}
+		columns[info.InName] = info.ExName
+	}
+	for i := range pqw.tagKeys {
+		pqw.pw.SchemaHandler.Infos[i+2].Encoding = parquet.Encoding_PLAIN_DICTIONARY
+	}
+	pqw.pw.MarshalFunc = marshal.MarshalCSV
+	pqw.pw.RowGroupSize = ParquetRowGroupSize
+	pqw.pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	return
+}
+
+func parquetElement(name string, typ parquet.Type, logical *parquet.LogicalType) *parquet.SchemaElement {
+	element := parquet.NewSchemaElement()
+	element.Name = name
+	element.Type = parquet.TypePtr(typ)
+	element.RepetitionType = parquet.FieldRepetitionTypePtr(parquet.FieldRepetitionType_OPTIONAL)
+	element.LogicalType = logical
+	// converted types are kept for readers without logical types
+	if logical != nil && logical.STRING != nil {
+		element.ConvertedType = parquet.ConvertedTypePtr(parquet.ConvertedType_UTF8)
+	} else if logical != nil && logical.TIMESTAMP.Unit.MILLIS != nil {
+		element.ConvertedType = parquet.ConvertedTypePtr(parquet.ConvertedType_TIMESTAMP_MILLIS)
+	} else if logical != nil && logical.TIMESTAMP.Unit.MICROS != nil {
+		element.ConvertedType = parquet.ConvertedTypePtr(parquet.ConvertedType_TIMESTAMP_MICROS)
+	}
+	return element
+}
+
+func parquetString() *parquet.LogicalType {
+	logical := parquet.NewLogicalType()
+	logical.STRING = parquet.NewStringType()
+	return logical
+}
+
+// Close writes the rest row group and the footer of the parquet file.
+func (pqw *parquetWriter) Close() (err error) {
+	if pqw.of == nil {
+		return nil
+	}
+	if pqw.pw != nil {
+		err = pqw.pw.WriteStop()
+	}
+	if cerr := pqw.of.Close(); err == nil {
+		err = cerr
+	}
+	pqw.stat.Size, pqw.stat.SHA256 = pqw.of.Size(), pqw.of.Sum()
+	return
+}
+
+func (pqw *parquetWriter) Stats() []*FileStat {
+	if pqw.stat == nil {
+		return nil
+	}
+	return []*FileStat{pqw.stat}
+}
@@ -0,0 +1,133 @@
+package tool
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/influxdata/influxdb1-client/models"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+)
+
+// parquetFile reads a parquet file from the local file system.
+type parquetFile struct {
+	*os.File
+}
+
+func (pf *parquetFile) Open(name string) (source.ParquetFile, error) {
+	if name == "" {
+		// column buffers read the same file with their own handles
+		name = pf.Name()
+	}
+	f, err := os.Open(name)
+	return &parquetFile{f}, err
+}
+
+func (pf *parquetFile) Create(name string) (source.ParquetFile, error) {
+	f, err := os.Create(name)
+	return &parquetFile{f}, err
+}
+
+func TestParquetWriter(t *testing.T) {
+	tests := []struct {
+		precision string
+		scale     int64
+		unit      string
+	}{
+		{precision: "ns", scale: 1, unit: "NANOS"},
+		{precision: "ms", scale: 1, unit: "MILLIS"},
+		// timestamps in seconds, minutes or hours are rescaled to milliseconds
+		{precision: "s", scale: 1e3, unit: "MILLIS"},
+		{precision: "m", scale: 60e3, unit: "MILLIS"},
+		{precision: "h", scale: 3600e3, unit: "MILLIS"},
+	}
+	for _, tt := range tests {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		fieldMap := map[string]string{"count": "integer", "note": "string", "ok": "boolean", "usage": "float"}
+		pqw := &parquetWriter{
+			seriesColumns: newSeriesColumns([]string{"host", "region"}, fieldMap, map[string]string{"host": "host", "region": "region"},
+				map[string]string{"count": "count", "note": "note", "ok": "ok", "usage": "usage"}, nil),
+			path:      filepath.Join(dir, "cpu.parquet"),
+			precision: tt.precision,
+		}
+		row := &models.Row{
+			Columns: []string{"time", "host", "region", "count", "note", "ok", "usage"},
+			Values: [][]interface{}{
+				{json.Number("1"), "h0", "eu", json.Number("3"), "n", true, json.Number("0.5")},
+				{json.Number("2"), "h0", nil, nil, nil, false, json.Number("1.5")},
+				{json.Number("3"), "h1", "us", json.Number("5"), "m", nil, nil},
+			},
+		}
+		if err := pqw.WriteSeries(row); err != nil {
+			t.Fatal(err)
+		}
+		if err := pqw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if stat := pqw.Stats()[0]; stat.Points != 3 || stat.MinTime != 1 || stat.MaxTime != 3 {
+			t.Errorf("%s: stat = %+v", tt.precision, stat)
+		}
+
+		f, err := os.Open(pqw.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pr, err := reader.NewParquetColumnReader(&parquetFile{f}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// names of the footer are renamed by the reader into identifiers, while the names written are kept in infos
+		schema, infos := pr.Footer.Schema[1:], pr.SchemaHandler.Infos[1:]
+		types := make([]string, 0, len(schema))
+		for i, element := range schema {
+			types = append(types, infos[i].ExName+" "+element.Type.String())
+		}
+		want := []string{"time INT64", "host BYTE_ARRAY", "region BYTE_ARRAY", "count INT64", "note BYTE_ARRAY", "ok BOOLEAN", "usage DOUBLE"}
+		if !reflect.DeepEqual(types, want) {
+			t.Errorf("%s: columns = %v, want %v", tt.precision, types, want)
+		}
+		unit := schema[0].LogicalType.TIMESTAMP.Unit
+		if units := map[string]bool{"NANOS": unit.NANOS != nil, "MICROS": unit.MICROS != nil, "MILLIS": unit.MILLIS != nil}; !units[tt.unit] {
+			t.Errorf("%s: time unit = %v, want %s", tt.precision, unit, tt.unit)
+		}
+		if schema[0].GetRepetitionType() != parquet.FieldRepetitionType_REQUIRED || schema[1].GetRepetitionType() != parquet.FieldRepetitionType_OPTIONAL {
+			t.Errorf("%s: repetition types of time and tags = %v, %v", tt.precision, schema[0].RepetitionType, schema[1].RepetitionType)
+		}
+		// tag columns are dictionary encoded
+		for i, column := range pr.Footer.RowGroups[0].Columns[1:3] {
+			dict := false
+			for _, encoding := range column.MetaData.Encodings {
+				dict = dict || encoding == parquet.Encoding_PLAIN_DICTIONARY || encoding == parquet.Encoding_RLE_DICTIONARY
+			}
+			if !dict {
+				t.Errorf("%s: encodings of %s = %v", tt.precision, infos[i+1].ExName, column.MetaData.Encodings)
+			}
+		}
+
+		wantValues := [][]interface{}{
+			{1 * tt.scale, 2 * tt.scale, 3 * tt.scale},
+			{"h0", "h0", "h1"},
+			{"eu", nil, "us"},
+			{int64(3), nil, int64(5)},
+			{"n", nil, "m"},
+			{true, false, nil},
+			{0.5, 1.5, nil},
+		}
+		for i, w := range wantValues {
+			values, _, _, err := pr.ReadColumnByIndex(int64(i), 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(values, w) {
+				t.Errorf("%s: column %s = %v, want %v", tt.precision, infos[i].ExName, values, w)
+			}
+		}
+		pr.ReadStop()
+		f.Close()
+	}
+}
@@ -0,0 +1,112 @@
+package tool
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/chengshiwen/influx-tool/util"
+)
+
+// keyPattern matches a tag key or field key, optionally scoped to the measurements matching meas.
+type keyPattern struct {
+	meas string
+	key  string
+}
+
+func (kp keyPattern) match(meas, key string) bool {
+	return (kp.meas == "" || util.WildcardMatch(kp.meas, meas)) && util.WildcardMatch(kp.key, key)
+}
+
+// Projection selects the tag keys and field keys to export.
+// Each key is given as 'key' for all measurements or 'measurement:key' for the matching measurements,
+// wildcard '*' and '?' supported in both parts.
+type Projection struct {
+	includeTags   []keyPattern
+	excludeTags   []keyPattern
+	includeFields []keyPattern
+	excludeFields []keyPattern
+}
+
+func parseKeyPatterns(str string) []keyPattern {
+	var patterns []keyPattern
+	for _, s := range util.String2Array(str) {
+		if idx := strings.LastIndex(s, ":"); idx > -1 {
+			patterns = append(patterns, keyPattern{meas: s[:idx], key: s[idx+1:]})
+		} else {
+			patterns = append(patterns, keyPattern{key: s})
+		}
+	}
+	return patterns
+}
+
+// NewProjection returns nil if no key is given, which means all tag keys and field keys are exported.
+func NewProjection(includeTags, excludeTags, includeFields, excludeFields string) *Projection {
+	if includeTags == "" && excludeTags == "" && includeFields == "" && excludeFields == "" {
+		return nil
+	}
+	return &Projection{
+		includeTags:   parseKeyPatterns(includeTags),
+		excludeTags:   parseKeyPatterns(excludeTags),
+		includeFields: parseKeyPatterns(includeFields),
+		excludeFields: parseKeyPatterns(excludeFields),
+	}
+}
+
+// selected reports whether the key is chosen: it must match an include pattern if any applies
+// to the measurement, and must not match any exclude pattern.
+func selected(includes, excludes []keyPattern, meas, key string) bool {
+	included, scoped := false, false
+	for _, kp := range includes {
+		if kp.meas == "" || util.WildcardMatch(kp.meas, meas) {
+			scoped = true
+			if util.WildcardMatch(kp.key, key) {
+				included = true
+				break
+			}
+		}
+	}
+	if scoped && !included {
+		return false
+	}
+	for _, kp := range excludes {
+		if kp.match(meas, key) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Projection) TagKeys(meas string, tagKeys []string) []string {
+	keys := make([]string, 0, len(tagKeys))
+	for _, key := range tagKeys {
+		if selected(p.includeTags, p.excludeTags, meas, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (p *Projection) FieldKeys(meas string, fieldKeys map[string][]string) map[string][]string {
+	keys := make(map[string][]string, len(fieldKeys))
+	for key, types := range fieldKeys {
+		if selected(p.includeFields, p.excludeFields, meas, key) {
+			keys[key] = types
+		}
+	}
+	return keys
+}
+
+// selectKeys returns the quoted select list of the keys, sorted like the columns of 'select *'.
+func selectKeys(tagKeys []string, fieldKeys map[string][]string) []string {
+	keys := make([]string, 0, len(tagKeys)+len(fieldKeys))
+	keys = append(keys, tagKeys...)
+	for key := range fieldKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	selects := make([]string, 0, len(keys))
+	for _, key := range keys {
+		selects = append(selects, "\""+util.EscapeIdentifier(key)+"\"")
+	}
+	return selects
+}
@@ -0,0 +1,150 @@
+package tool
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chengshiwen/influx-tool/util"
+	"github.com/influxdata/influxdb1-client/models"
+)
+
+// NonNumericPolicies are the policies of string and boolean fields in the prometheus format:
+// skip drops them, while map writes booleans as 1 or 0 and strings as 1 with the string in the label value.
+var NonNumericPolicies = util.NewSetFromSlice([]string{"skip", "map"})
+
+// promWriter writes the points of a measurement in the prometheus text exposition format, which VictoriaMetrics
+// imports, as a sample line of the metric named <measurement>_<field> for each field value,
+// with the tags as labels and the timestamp in milliseconds.
+type promWriter struct {
+	*seriesColumns
+	f      *rotateFile
+	meas   string
+	unit   int64
+	policy string
+
+	metrics map[string]string
+	labels  map[string]string
+}
+
+func (pw *promWriter) WriteSeries(row *models.Row) error {
+	if pw.metrics == nil {
+		pw.metrics, pw.labels = make(map[string]string), make(map[string]string)
+	}
+	return pw.decode(row, func(p *seriesPoint) error {
+		ts := strconv.FormatInt(p.time*pw.unit/1e6, 10)
+		labels := make(map[string]string, len(p.tags))
+		for _, tag := range p.tags {
+			pw.addLabel(labels, pw.tagNames[tag[0]], tag[1])
+		}
+		samples := make([]promSample, 0, len(p.fields))
+		for _, field := range p.fields {
+			if pw.policy == "skip" && (field.vtype == "string" || field.vtype == "boolean") {
+				continue
+			}
+			sample := promSample{metric: pw.metric(field.key), value: formatValue(field.value)}
+			switch v := field.value.(type) {
+			case bool:
+				if sample.value = "0"; v {
+					sample.value = "1"
+				}
+			case string:
+				sample.value, sample.str, sample.labeled = "1", v, true
+			}
+			samples = append(samples, sample)
+		}
+		names := make([]string, 0, len(labels))
+		for name := range labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		pairs := make([]string, 0, len(names))
+		for _, name := range names {
+			pairs = append(pairs, name+"=\""+promEscape(labels[name])+"\"")
+		}
+		for _, sample := range samples {
+			set := pairs
+			if sample.labeled {
+				// the string is written in the label value, in place of a tag of the same name
+				set = make([]string, 0, len(pairs)+1)
+				for i, name := range names {
+					if name != "value" {
+						set = append(set, pairs[i])
+					}
+				}
+				set = append(set, "value=\""+promEscape(sample.str)+"\"")
+			}
+			if err := pw.f.WritePoint(fmt.Sprintf("%s{%s} %s %s\n", sample.metric, strings.Join(set, ","), sample.value, ts), p.time); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// promSample is a sample of a field, with the string of a string field written in the label value.
+type promSample struct {
+	metric  string
+	value   string
+	str     string
+	labeled bool
+}
+
+// metric returns the sanitized metric name of the field, cached by field key.
+func (pw *promWriter) metric(k string) string {
+	name, ok := pw.metrics[k]
+	if !ok {
+		name = promName(pw.meas+"_"+pw.fieldNames[k], true)
+		pw.metrics[k] = name
+	}
+	return name
+}
+
+// addLabel adds the label of the tag with its name sanitized, where the first of the tags sanitized into a name wins.
+func (pw *promWriter) addLabel(labels map[string]string, key, value string) {
+	name, ok := pw.labels[key]
+	if !ok {
+		name = promName(key, false)
+		pw.labels[key] = name
+	}
+	if _, ok := labels[name]; !ok {
+		labels[name] = value
+	}
+}
+
+func (pw *promWriter) Close() error {
+	return pw.f.Close()
+}
+
+func (pw *promWriter) Stats() []*FileStat {
+	return pw.f.Stats()
+}
+
+// promName sanitizes the name of a metric or label, replacing invalid characters with underscores,
+// where colons are only valid in metric names and names must not start with a digit.
+// Label names starting with __ are reserved, such as __name__ for the metric name, so they are prefixed with tag.
+func promName(name string, metric bool) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || (metric && c == ':') {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	s := b.String()
+	if !metric && strings.HasPrefix(s, "__") {
+		return "tag" + s
+	}
+	if s != "" && (s[0] < '0' || s[0] > '9') {
+		return s
+	}
+	return "_" + s
+}
+
+// promEscape escapes the backslashes, double quotes and line feeds of a label value.
+func promEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,102 @@
+package tool
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/influxdata/influxdb1-client/models"
+)
+
+func TestPromName(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric bool
+		want   string
+	}{
+		{name: "cpu_usage", metric: true, want: "cpu_usage"},
+		{name: "disk io_read.bytes", metric: true, want: "disk_io_read_bytes"},
+		{name: "job:rate", metric: true, want: "job:rate"},
+		{name: "job:rate", metric: false, want: "job_rate"},
+		// names must not start with a digit
+		{name: "1m_load", metric: true, want: "_1m_load"},
+		{name: "9dc", metric: false, want: "_9dc"},
+		{name: "", metric: false, want: "_"},
+		// label names starting with __ are reserved
+		{name: "__name__", metric: false, want: "tag__name__"},
+		{name: "__1", metric: false, want: "tag__1"},
+		{name: "__name___usage", metric: true, want: "__name___usage"},
+		{name: "温度", metric: true, want: "______"},
+		{name: "温度", metric: false, want: "tag______"},
+	}
+	for _, tt := range tests {
+		if got := promName(tt.name, tt.metric); got != tt.want {
+			t.Errorf("promName(%q, %v) = %s, want %s", tt.name, tt.metric, got, tt.want)
+		}
+	}
+}
+
+func TestPromEscape(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "web-01", want: "web-01"},
+		{value: `say "hi"`, want: `say \"hi\"`},
+		{value: `C:\temp`, want: `C:\\temp`},
+		{value: "a\nb", want: `a\nb`},
+		{value: "a,b=c{d}", want: "a,b=c{d}"},
+	}
+	for _, tt := range tests {
+		if got := promEscape(tt.value); got != tt.want {
+			t.Errorf("promEscape(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestPromWriterMap(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	tagKeys := []string{"1dc", "__name__", "host", "value"}
+	fieldMap := map[string]string{"note": "string", "ok": "boolean", "usage": "float"}
+	pw := &promWriter{
+		seriesColumns: newSeriesColumns(tagKeys, fieldMap, map[string]string{"1dc": "1dc", "__name__": "__name__", "host": "host", "value": "value"},
+			map[string]string{"note": "note", "ok": "ok", "usage": "usage"}, nil),
+		f:      newRotateFile(filepath.Join(dir, "cpu"), ".prom", false, FileLimit{}, nil),
+		meas:   "cpu",
+		unit:   1e9,
+		policy: "map",
+	}
+	row := &models.Row{
+		Columns: []string{"time", "1dc", "__name__", "host", "value", "note", "ok", "usage"},
+		Values: [][]interface{}{
+			{json.Number("1"), "eu", "x", "h0", "v", "say \"hi\"", true, json.Number("0.5")},
+			{json.Number("2"), nil, nil, "h1", nil, nil, false, nil},
+		},
+	}
+	if err := pw.WriteSeries(row); err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "cpu.prom"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// strings are written in the label value in place of the tag value, and booleans as 1 or 0
+	want := `cpu_note{_1dc="eu",host="h0",tag__name__="x",value="say \"hi\""} 1 1000
+cpu_ok{_1dc="eu",host="h0",tag__name__="x",value="v"} 1 1000
+cpu_usage{_1dc="eu",host="h0",tag__name__="x",value="v"} 0.5 1000
+cpu_ok{host="h1"} 0 2000
+`
+	if string(data) != want {
+		t.Errorf("prometheus file = %q, want %q", data, want)
+	}
+	if stats := pw.Stats(); !reflect.DeepEqual([]int64{stats[0].Points, stats[0].MinTime, stats[0].MaxTime}, []int64{4, 1, 2}) {
+		t.Errorf("stat = %+v", stats[0])
+	}
+}
@@ -0,0 +1,214 @@
+package tool
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb1-client/models"
+)
+
+// sqlTypes maps the field types to the column types of PostgreSQL.
+var sqlTypes = map[string]string{
+	"float":   "double precision",
+	"integer": "bigint",
+	"boolean": "boolean",
+	"string":  "text",
+}
+
+// sqlWriter writes the points of a measurement as a sql dump for PostgreSQL or TimescaleDB, with a table created
+// of a timestamptz column named time, text columns of tags and columns of fields typed by the field types,
+// followed by INSERT statements of multiple rows. Every statement is written in a line,
+// and every part starts with the CREATE TABLE statement, optionally followed by create_hypertable.
+type sqlWriter struct {
+	*seriesColumns
+	f          *rotateFile
+	meas       string
+	unit       int64
+	batch      int
+	hypertable bool
+
+	insert string
+	rows   []string
+	times  []int64
+}
+
+func (sw *sqlWriter) WriteSeries(row *models.Row) error {
+	if sw.insert == "" {
+		sw.init()
+	}
+	return sw.decode(row, func(p *seriesPoint) error {
+		record := sw.record(p)
+		values := make([]string, len(record))
+		values[0] = sqlString(time.Unix(0, p.time*sw.unit).UTC().Format(time.RFC3339Nano))
+		for i, v := range record[1:] {
+			if v != nil {
+				values[i+1] = sqlValue(v)
+			} else {
+				values[i+1] = "NULL"
+			}
+		}
+		sw.rows = append(sw.rows, "("+strings.Join(values, ", ")+")")
+		sw.times = append(sw.times, p.time)
+		if len(sw.rows) >= sw.batch {
+			return sw.flush()
+		}
+		return nil
+	})
+}
+
+func (sw *sqlWriter) init() {
+	table := sqlIdentifier(sw.meas)
+	names := []string{sqlIdentifier("time")}
+	definitions := []string{sqlIdentifier("time") + " timestamptz NOT NULL"}
+	for _, k := range sw.tagKeys {
+		name := sqlIdentifier(sw.tagNames[k])
+		names = append(names, name)
+		definitions = append(definitions, name+" text")
+	}
+	for _, k := range sw.fieldKeys {
+		name := sqlIdentifier(sw.fieldNames[k])
+		names = append(names, name)
+		definitions = append(definitions, name+" "+sqlTypes[sw.fieldMap[k]])
+	}
+	header := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", table, strings.Join(definitions, ", "))
+	if sw.hypertable {
+		header += fmt.Sprintf("\nSELECT create_hypertable(%s, 'time', if_not_exists => TRUE);", sqlString(table))
+	}
+	sw.f.header = func() string { return header }
+	sw.insert = fmt.Sprintf("INSERT INTO %s (%s) VALUES ", table, strings.Join(names, ", "))
+}
+
+// flush writes the rows batched as an INSERT statement.
+func (sw *sqlWriter) flush() error {
+	err := sw.f.WritePoints(sw.insert+strings.Join(sw.rows, ", ")+";\n", sw.times)
+	sw.rows, sw.times = sw.rows[:0], sw.times[:0]
+	return err
+}
+
+// Close writes the rest rows batched.
+func (sw *sqlWriter) Close() (err error) {
+	if len(sw.rows) > 0 {
+		err = sw.flush()
+	}
+	if cerr := sw.f.Close(); err == nil {
+		err = cerr
+	}
+	return
+}
+
+func (sw *sqlWriter) Stats() []*FileStat {
+	return sw.f.Stats()
+}
+
+// sqlIdentifier quotes the name as an identifier of PostgreSQL.
+func sqlIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// sqlString quotes the value as a string constant of PostgreSQL, as an escape string constant
+// if containing line breaks or backslashes, so that every statement is written in a line.
+func sqlString(value string) string {
+	value = strings.ReplaceAll(value, "'", "''")
+	if !strings.ContainsAny(value, "\\\n\r") {
+		return "'" + value + "'"
+	}
+	value = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`).Replace(value)
+	return "E'" + value + "'"
+}
+
+// sqlValue returns the constant of a tag or field value converted by castValue, where only strings are quoted.
+func sqlValue(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return formatFloat(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return sqlString(v.(string))
+	}
+}
+
+// sqlTimes returns the times of the rows of an INSERT statement, which are the first values of the rows.
+func sqlTimes(line, precision string) ([]int64, error) {
+	idx := strings.Index(line, ") VALUES (")
+	if idx < 0 {
+		return nil, fmt.Errorf("invalid statement: %s", line)
+	}
+	times := make([]int64, 0)
+	first := false
+	for i := idx + len(") VALUES "); i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '(':
+			first = true
+		case c == ',':
+			first = false
+		case c == '\'' || (c == 'E' && i+1 < len(line) && line[i+1] == '\''):
+			escape := c == 'E'
+			if escape {
+				i++
+			}
+			// skip over the string constant, where quotes are doubled and backslashes escape if an escape string
+			j := i + 1
+			for ; j < len(line); j++ {
+				if escape && line[j] == '\\' {
+					j++
+				} else if line[j] == '\'' {
+					if j+1 < len(line) && line[j+1] == '\'' {
+						j++
+					} else {
+						break
+					}
+				}
+			}
+			if first {
+				t, err := parseTime(line[i+1:j], precision)
+				if err != nil {
+					return nil, err
+				}
+				times = append(times, t)
+				first = false
+			}
+			i = j
+		}
+	}
+	return times, nil
+}
+
+// mergeSQLFiles concatenates sql dumps, where every part of the merged file starts with the statements
+// creating the table it continues to insert into.
+func mergeSQLFiles(paths []string, base, ext string, opts *ExportOptions) (*rotateFile, error) {
+	var header []string
+	rf, err := openMergeFile(base, ext, opts, func() string {
+		return strings.TrimSuffix(strings.Join(header, ""), "\n")
+	})
+	if err != nil {
+		return rf, err
+	}
+	for _, path := range paths {
+		err := readLines(path, opts.Compress, func(line string) error {
+			switch {
+			case strings.HasPrefix(line, "CREATE TABLE"):
+				header = []string{line}
+				return rf.WriteLine(line)
+			case strings.HasPrefix(line, "INSERT INTO"):
+				times, err := sqlTimes(line, opts.Precision)
+				if err != nil {
+					return err
+				}
+				return rf.WritePoints(line, times)
+			default:
+				header = append(header, line)
+				return rf.WriteLine(line)
+			}
+		})
+		if err != nil {
+			return rf, err
+		}
+	}
+	return rf, nil
+}
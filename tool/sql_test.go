@@ -0,0 +1,72 @@
+package tool
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSQLValue(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{v: 1.5, want: "1.5"},
+		{v: 1e21, want: "1e+21"},
+		{v: int64(-3), want: "-3"},
+		{v: true, want: "true"},
+		{v: "it's", want: "'it''s'"},
+		{v: "x'); DROP TABLE cpu; --", want: "'x''); DROP TABLE cpu; --'"},
+		{v: "a\\b\nc", want: `E'a\\b\nc'`},
+	}
+	for _, tt := range tests {
+		if got := sqlValue(tt.v); got != tt.want {
+			t.Errorf("sqlValue(%#v) = %s, want %s", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestSQLTimes(t *testing.T) {
+	tests := []struct {
+		line      string
+		precision string
+		want      []int64
+		err       bool
+	}{
+		{
+			line:      `INSERT INTO "cpu" ("time", "host", "usage") VALUES ('2020-09-13T12:26:40Z', 'h0', 0.5);`,
+			precision: "s",
+			want:      []int64{1600000000},
+		},
+		{
+			// commas, parentheses and quotes inside string constants are skipped over
+			line: `INSERT INTO "cpu" ("time", "host", "note") VALUES ('2020-09-13T12:26:40.5Z', 'a, (b''', NULL), ` +
+				`('2020-09-13T12:26:41Z', NULL, E'x\'), (\\''y'), ('2020-09-13T12:26:42Z', 'z', 'w');`,
+			precision: "ms",
+			want:      []int64{1600000000500, 1600000001000, 1600000002000},
+		},
+		{
+			line:      `CREATE TABLE "cpu" ("time" timestamptz NOT NULL);`,
+			precision: "s",
+			err:       true,
+		},
+		{
+			line:      `INSERT INTO "cpu" ("time", "usage") VALUES ('yesterday', 0.5);`,
+			precision: "s",
+			err:       true,
+		},
+	}
+	for _, tt := range tests {
+		got, err := sqlTimes(tt.line, tt.precision)
+		if tt.err {
+			if err == nil {
+				t.Errorf("sqlTimes(%q) = %v, want error", tt.line, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("sqlTimes(%q) error: %s", tt.line, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sqlTimes(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package tool
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/influxdata/influxdb1-client/models"
+	// pure go driver without cgo, so that static cross builds keep working
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteFile is the name of the sqlite database file in the export directory of each database and retention policy.
+const SQLiteFile = "export.db"
+
+// sqliteTypes maps the field types to the column types of SQLite.
+var sqliteTypes = map[string]string{
+	"float":   "REAL",
+	"integer": "INTEGER",
+	"boolean": "BOOLEAN",
+	"string":  "TEXT",
+}
+
+// SQLiteDB is a sqlite database file, where the measurements of a database and retention policy
+// are exported into tables. It is written by one connection, shared by the measurements in turn.
+type SQLiteDB struct {
+	path string
+	db   *sql.DB
+}
+
+// OpenSQLiteDB opens the sqlite database file in dir, created if not existed.
+func OpenSQLiteDB(dir string) (*SQLiteDB, error) {
+	path := filepath.Join(dir, SQLiteFile)
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	return &SQLiteDB{path: path, db: db}, nil
+}
+
+// Path returns the path of the database file.
+func (sd *SQLiteDB) Path() string {
+	return sd.path
+}
+
+// DropTable drops the table left by a previous run, possibly partial.
+func (sd *SQLiteDB) DropTable(name string) error {
+	_, err := sd.db.Exec("DROP TABLE IF EXISTS " + sqlIdentifier(name))
+	return err
+}
+
+// Close closes the database file.
+func (sd *SQLiteDB) Close() error {
+	return sd.db.Close()
+}
+
+// SQLiteStat returns the stat of the database file at path after closed,
+// with the points and time range of the tables written from stats.
+func SQLiteStat(path string, stats []*FileStat) (*FileStat, error) {
+	stat := &FileStat{Path: path}
+	for _, s := range stats {
+		if s.Points == 0 {
+			continue
+		}
+		if stat.Points == 0 || s.MinTime < stat.MinTime {
+			stat.MinTime = s.MinTime
+		}
+		if stat.Points == 0 || s.MaxTime > stat.MaxTime {
+			stat.MaxTime = s.MaxTime
+		}
+		stat.Points += s.Points
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if stat.Size, err = io.Copy(hash, f); err != nil {
+		return nil, err
+	}
+	stat.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return stat, nil
+}
+
+// sqliteWriter writes the points of a measurement into a table of the sqlite database, with an integer column
+// named time in the precision, text columns of tags and columns of fields typed by the field types.
+// The table is created on the first write, and the points are inserted in a transaction every ChunkSize rows.
+// The time index is created when closed, which is faster than maintained along with inserts.
+type sqliteWriter struct {
+	*seriesColumns
+	db    *SQLiteDB
+	table string
+
+	insert string
+	rows   [][]interface{}
+	stat   *FileStat
+}
+
+func (sw *sqliteWriter) WriteSeries(row *models.Row) error {
+	return sw.decode(row, func(p *seriesPoint) error {
+		if sw.stat == nil {
+			if err := sw.create(); err != nil {
+				return err
+			}
+		}
+		sw.rows = append(sw.rows, sw.record(p))
+		sw.stat.add(p.time)
+		if len(sw.rows) >= ChunkSize {
+			return sw.flush()
+		}
+		return nil
+	})
+}
+
+func (sw *sqliteWriter) create() error {
+	names := []string{sqlIdentifier("time")}
+	definitions := []string{sqlIdentifier("time") + " INTEGER NOT NULL"}
+	for _, k := range sw.tagKeys {
+		name := sqlIdentifier(sw.tagNames[k])
+		names = append(names, name)
+		definitions = append(definitions, name+" TEXT")
+	}
+	for _, k := range sw.fieldKeys {
+		name := sqlIdentifier(sw.fieldNames[k])
+		names = append(names, name)
+		definitions = append(definitions, name+" "+sqliteTypes[sw.fieldMap[k]])
+	}
+	table := sqlIdentifier(sw.table)
+	if _, err := sw.db.db.Exec(fmt.Sprintf("CREATE TABLE %s (%s)", table, strings.Join(definitions, ", "))); err != nil {
+		return err
+	}
+	params := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+	sw.insert = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(names, ", "), params)
+	sw.stat = &FileStat{Path: sw.db.path}
+	return nil
+}
+
+// flush inserts the rows buffered in a transaction.
+func (sw *sqliteWriter) flush() (err error) {
+	tx, err := sw.db.db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	stmt, err := tx.Prepare(sw.insert)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	for _, record := range sw.rows {
+		if _, err = stmt.Exec(record...); err != nil {
+			return
+		}
+	}
+	sw.rows = sw.rows[:0]
+	return tx.Commit()
+}
+
+// Close inserts the rest rows and creates the time index of the table.
+func (sw *sqliteWriter) Close() (err error) {
+	if sw.stat == nil {
+		return nil
+	}
+	if len(sw.rows) > 0 {
+		if err = sw.flush(); err != nil {
+			return
+		}
+	}
+	_, err = sw.db.db.Exec(fmt.Sprintf("CREATE INDEX %s ON %s (%s)",
+		sqlIdentifier("idx_"+sw.table+"_time"), sqlIdentifier(sw.table), sqlIdentifier("time")))
+	return
+}
+
+func (sw *sqliteWriter) Stats() []*FileStat {
+	if sw.stat == nil {
+		return nil
+	}
+	return []*FileStat{sw.stat}
+}
@@ -0,0 +1,77 @@
+package tool
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// StateFile is the default name of the state file of incremental exports, in the parent of the export directory,
+// since the files of an export directory are replaced by each run.
+const StateFile = "state.json"
+
+// State keeps the watermark of each measurement exported incrementally,
+// as the time in nanoseconds up to which the data has been exported, by database and retention policy.
+type State struct {
+	path string
+	mu   sync.Mutex
+
+	Watermarks map[string]map[string]map[string]int64 `json:"watermarks"`
+}
+
+// LoadState loads the state file at path, or an empty state if not existed.
+func LoadState(path string) (*State, error) {
+	st := &State{path: path, Watermarks: make(map[string]map[string]map[string]int64)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return st, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, st); err != nil {
+		return nil, err
+	}
+	if st.Watermarks == nil {
+		st.Watermarks = make(map[string]map[string]map[string]int64)
+	}
+	return st, nil
+}
+
+// Watermark returns the watermark of the measurement, false if never exported.
+func (st *State) Watermark(db, rp, meas string) (int64, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	t, ok := st.Watermarks[db][rp][meas]
+	return t, ok
+}
+
+// Advance moves the watermark of the measurement forward to t, which is saved by Save.
+func (st *State) Advance(db, rp, meas string, t int64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.Watermarks[db] == nil {
+		st.Watermarks[db] = make(map[string]map[string]int64)
+	}
+	if st.Watermarks[db][rp] == nil {
+		st.Watermarks[db][rp] = make(map[string]int64)
+	}
+	if last, ok := st.Watermarks[db][rp][meas]; !ok || t > last {
+		st.Watermarks[db][rp][meas] = t
+	}
+}
+
+// Save writes the state file atomically, so that a crash leaves the previous state.
+func (st *State) Save() error {
+	st.mu.Lock()
+	data, err := json.MarshalIndent(st, "", "  ")
+	st.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := st.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, st.path)
+}
@@ -0,0 +1,124 @@
+package tool
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/influxdata/influxdb1-client/models"
+)
+
+type timeSlice struct {
+	whereClause string
+}
+
+// windowSlices splits the time range of the measurement into consecutive windows, or a single slice without window.
+// The range is first narrowed to the times of the first and last points, so that an unbounded -start or -end
+// does not produce an endless run of empty windows, or of empty intervals when downsampling with fill.
+func (me *MeasurementExport) windowSlices() (slices []timeSlice, err error) {
+	start, end := me.timeRange()
+	first, ok, err := me.boundTime("asc")
+	if err != nil || !ok {
+		return
+	}
+	last, _, err := me.boundTime("desc")
+	if err != nil {
+		return
+	}
+	align := me.opts.Window
+	if align == 0 {
+		align = int64(me.opts.Downsample.Interval)
+	}
+	// windows are aligned to multiples of the window since epoch, like group by time() intervals,
+	// so that an interval is never split across slices, while only the first slice is clamped to the start
+	lower := first - first%align
+	if first%align < 0 {
+		lower -= align
+	}
+	if last < end {
+		end = last
+	}
+	if me.opts.Window == 0 {
+		slices = append(slices, timeSlice{whereClause: me.whereClause(fmt.Sprintf("time >= %d and time <= %d", maxTime(lower, start), end))})
+		return
+	}
+	for ; lower <= end; lower += me.opts.Window {
+		upper := lower + me.opts.Window
+		if upper > end || upper < lower {
+			slices = append(slices, timeSlice{whereClause: me.whereClause(fmt.Sprintf("time >= %d and time <= %d", maxTime(lower, start), end))})
+			break
+		}
+		slices = append(slices, timeSlice{whereClause: me.whereClause(fmt.Sprintf("time >= %d and time < %d", maxTime(lower, start), upper))})
+	}
+	return
+}
+
+func maxTime(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// boundTime returns the time of the first or last point within the time range.
+func (me *MeasurementExport) boundTime(order string) (t int64, ok bool, err error) {
+	q := fmt.Sprintf("select * from %s %s order by time %s limit 1",
+		me.source(), me.whereClause(me.timeCond()), order)
+	err = me.be.QueryIQLChunk("GET", me.opts.Database, q, "ns", ChunkSize, func(series models.Rows) error {
+		if len(series[0].Values) > 0 {
+			n, err := series[0].Values[0][0].(json.Number).Int64()
+			if err != nil {
+				return err
+			}
+			t, ok = n, true
+		}
+		return nil
+	})
+	return
+}
+
+// spool saves the query results of a slice to a temporary file until the slice is next in line.
+func (me *MeasurementExport) spool(ts timeSlice, path string) (err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return
+	}
+	bw := bufio.NewWriterSize(f, WriterBufferSize)
+	enc := json.NewEncoder(bw)
+	err = me.query(ts, func(series models.Rows) error {
+		for _, row := range series {
+			if err := enc.Encode(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if ferr := bw.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return
+}
+
+func replaySpool(path string, fn func(series models.Rows) error) (err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	dec := json.NewDecoder(bufio.NewReaderSize(f, WriterBufferSize))
+	dec.UseNumber()
+	for dec.More() {
+		row := &models.Row{}
+		if err = dec.Decode(row); err != nil {
+			return
+		}
+		if err = fn(models.Rows{row}); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,387 @@
+package tool
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/util"
+	"github.com/influxdata/influxdb1-client/models"
+)
+
+// seriesWriter writes the rows of query results into an export file.
+// The file is created on the first write, so no file is left for a measurement without data,
+// and is rotated into numbered parts if limited.
+type seriesWriter interface {
+	WriteSeries(row *models.Row) error
+	Close() error
+	Stats() []*FileStat
+}
+
+// seriesColumns decodes the rows of query results into points, shared by the writers of a measurement.
+// The columns are the time, tags and fields, followed by companion columns such as "f"::string selected
+// for a field of mixed types. Since select * only returns the fields of the shards queried, the companion
+// columns are found by their position from the end of the row, rather than from the fields of the measurement.
+type seriesColumns struct {
+	tagKeys    []string
+	tagMap     util.Set
+	fieldMap   map[string]string
+	tagNames   map[string]string
+	fieldNames map[string]string
+	companions []string
+
+	fieldKeys []string
+	index     map[string]int
+}
+
+func newSeriesColumns(tagKeys []string, fieldMap map[string]string, tagNames, fieldNames map[string]string, companions []string) *seriesColumns {
+	sc := &seriesColumns{
+		tagKeys:    tagKeys,
+		tagMap:     util.NewSetFromSlice(tagKeys),
+		fieldMap:   fieldMap,
+		tagNames:   tagNames,
+		fieldNames: fieldNames,
+		companions: companions,
+	}
+	sc.fieldKeys = make([]string, 0, len(fieldMap))
+	for k := range fieldMap {
+		sc.fieldKeys = append(sc.fieldKeys, k)
+	}
+	sort.Strings(sc.fieldKeys)
+	// the index of a tag is its column, while the index of a field is its position among fields
+	sc.index = make(map[string]int, len(sc.tagKeys)+len(sc.fieldKeys))
+	for i, k := range sc.tagKeys {
+		sc.index[k] = i + 1
+	}
+	for i, k := range sc.fieldKeys {
+		sc.index[k] = i
+	}
+	return sc
+}
+
+// seriesPoint is a row decoded, with the tags and fields in the order of their columns.
+type seriesPoint struct {
+	time   int64
+	tags   [][2]string
+	fields []seriesField
+}
+
+// seriesField is a field value converted by castValue into the field type.
+type seriesField struct {
+	key   string
+	vtype string
+	value interface{}
+}
+
+// decode calls fn with every row of the series decoded into a point, where the values of a field are
+// converted into the field type, including the strings of a field of mixed types, or left out if not converted.
+func (sc *seriesColumns) decode(row *models.Row, fn func(p *seriesPoint) error) error {
+	// tags are returned along with the series instead of columns when grouped by tags
+	groupTags := make([][2]string, 0, len(row.Tags))
+	for k, v := range row.Tags {
+		if v != "" {
+			groupTags = append(groupTags, [2]string{k, v})
+		}
+	}
+	sort.Slice(groupTags, func(i, j int) bool { return groupTags[i][0] < groupTags[j][0] })
+	columns := row.Columns
+	for _, value := range row.Values {
+		t, err := value[0].(json.Number).Int64()
+		if err != nil {
+			return err
+		}
+		p := &seriesPoint{time: t, tags: append([][2]string{}, groupTags...), fields: make([]seriesField, 0, len(value)-1)}
+		first := len(value) - len(sc.companions)
+		for i := 1; i < len(value); i++ {
+			k := columns[i]
+			v := value[i]
+			if v == nil {
+				continue
+			}
+			if i >= first {
+				k = sc.companions[i-first]
+			} else if sc.tagMap[k] {
+				p.tags = append(p.tags, [2]string{k, v.(string)})
+				continue
+			}
+			vtype, ok := sc.fieldMap[k]
+			if !ok {
+				continue
+			}
+			if fv, ok := castValue(vtype, v); ok {
+				p.fields = append(p.fields, seriesField{key: k, vtype: vtype, value: fv})
+			}
+		}
+		if err = fn(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record returns the values of the point in the columns of the time, the tags and the fields sorted by key.
+func (sc *seriesColumns) record(p *seriesPoint) []interface{} {
+	record := make([]interface{}, 1+len(sc.tagKeys)+len(sc.fieldKeys))
+	record[0] = p.time
+	for _, tag := range p.tags {
+		if idx, ok := sc.index[tag[0]]; ok {
+			record[idx] = tag[1]
+		}
+	}
+	for _, field := range p.fields {
+		record[len(sc.tagKeys)+1+sc.index[field.key]] = field.value
+	}
+	return record
+}
+
+type lineWriter struct {
+	*seriesColumns
+	f    *rotateFile
+	meas string
+}
+
+func (lw *lineWriter) WriteSeries(row *models.Row) error {
+	return lw.decode(row, func(p *seriesPoint) error {
+		if len(p.fields) == 0 {
+			// an interval without any value filled
+			return nil
+		}
+		mtagSet := []string{util.EscapeMeasurement(lw.meas)}
+		for _, tag := range p.tags {
+			mtagSet = append(mtagSet, fmt.Sprintf("%s=%s", util.EscapeTag(lw.tagNames[tag[0]]), util.EscapeTag(tag[1])))
+		}
+		fieldSet := make([]string, 0, len(p.fields))
+		for _, field := range p.fields {
+			name := util.EscapeTag(lw.fieldNames[field.key])
+			switch field.vtype {
+			case "integer":
+				fieldSet = append(fieldSet, fmt.Sprintf("%s=%si", name, formatValue(field.value)))
+			case "string":
+				fieldSet = append(fieldSet, fmt.Sprintf("%s=\"%s\"", name, models.EscapeStringField(field.value.(string))))
+			default:
+				fieldSet = append(fieldSet, fmt.Sprintf("%s=%s", name, formatValue(field.value)))
+			}
+		}
+		mtagStr := strings.Join(mtagSet, ",")
+		fieldStr := strings.Join(fieldSet, ",")
+		return lw.f.WritePoint(fmt.Sprintf("%s %s %d\n", mtagStr, fieldStr, p.time), p.time)
+	})
+}
+
+func (lw *lineWriter) Close() error {
+	return lw.f.Close()
+}
+
+func (lw *lineWriter) Stats() []*FileStat {
+	return lw.f.Stats()
+}
+
+type csvWriter struct {
+	*seriesColumns
+	f        *rotateFile
+	meas     string
+	location *time.Location
+	unit     int64
+
+	buf   bytes.Buffer
+	csvw  *csv.Writer
+	names string
+}
+
+func (cw *csvWriter) WriteSeries(row *models.Row) error {
+	if cw.csvw == nil {
+		// records are formatted into the buffer and written line by line, so that every part has the header line
+		cw.csvw = csv.NewWriter(&cw.buf)
+		cw.f.header = func() string { return cw.names }
+		// the headers are the time, tags and fields of the measurement, since the columns returned by a query
+		// are only those of the shards in its time range, which differ among slices
+		names := []string{"name", "time"}
+		for _, k := range cw.tagKeys {
+			names = append(names, cw.tagNames[k])
+		}
+		for _, k := range cw.fieldKeys {
+			names = append(names, cw.fieldNames[k])
+		}
+		cw.csvw.Write(names)
+		cw.csvw.Flush()
+		cw.names = "\xEF\xBB\xBF" + strings.TrimSuffix(cw.buf.String(), "\n")
+		cw.buf.Reset()
+	}
+	return cw.decode(row, func(p *seriesPoint) error {
+		record := cw.record(p)
+		records := make([]string, 0, len(record)+1)
+		records = append(records, cw.meas)
+		if cw.location != nil {
+			records = append(records, time.Unix(0, p.time*cw.unit).In(cw.location).Format(time.RFC3339Nano))
+		} else {
+			records = append(records, strconv.FormatInt(p.time, 10))
+		}
+		for _, v := range record[1:] {
+			if v != nil {
+				records = append(records, formatValue(v))
+			} else {
+				records = append(records, "")
+			}
+		}
+		cw.csvw.Write(records)
+		cw.csvw.Flush()
+		if err := cw.csvw.Error(); err != nil {
+			return err
+		}
+		defer cw.buf.Reset()
+		return cw.f.WritePoint(cw.buf.String(), p.time)
+	})
+}
+
+func (cw *csvWriter) Close() error {
+	return cw.f.Close()
+}
+
+func (cw *csvWriter) Stats() []*FileStat {
+	return cw.f.Stats()
+}
+
+// jsonPoint is a point written as a json line, with typed field values.
+type jsonPoint struct {
+	Measurement string                 `json:"measurement"`
+	Tags        map[string]string      `json:"tags"`
+	Fields      map[string]interface{} `json:"fields"`
+	Time        interface{}            `json:"time"`
+}
+
+type jsonWriter struct {
+	*seriesColumns
+	f        *rotateFile
+	meas     string
+	location *time.Location
+	unit     int64
+
+	buf bytes.Buffer
+	enc *json.Encoder
+}
+
+func (jw *jsonWriter) WriteSeries(row *models.Row) error {
+	if jw.enc == nil {
+		jw.enc = json.NewEncoder(&jw.buf)
+		jw.enc.SetEscapeHTML(false)
+	}
+	return jw.decode(row, func(p *seriesPoint) error {
+		if len(p.fields) == 0 {
+			// an interval without any value filled
+			return nil
+		}
+		point := &jsonPoint{Measurement: jw.meas, Tags: make(map[string]string, len(p.tags)), Fields: make(map[string]interface{}, len(p.fields))}
+		for _, tag := range p.tags {
+			point.Tags[jw.tagNames[tag[0]]] = tag[1]
+		}
+		for _, field := range p.fields {
+			point.Fields[jw.fieldNames[field.key]] = jsonValue(field.value)
+		}
+		if jw.location != nil {
+			point.Time = time.Unix(0, p.time*jw.unit).In(jw.location).Format(time.RFC3339Nano)
+		} else {
+			point.Time = p.time
+		}
+		jw.buf.Reset()
+		if err := jw.enc.Encode(point); err != nil {
+			return err
+		}
+		return jw.f.WritePoint(jw.buf.String(), p.time)
+	})
+}
+
+// jsonValue returns the field value converted by castValue, where a float is always written with a decimal point.
+func jsonValue(v interface{}) interface{} {
+	if f, ok := v.(float64); ok {
+		s := formatFloat(f)
+		if !strings.ContainsAny(s, ".eE") {
+			s += ".0"
+		}
+		return json.Number(s)
+	}
+	return v
+}
+
+// castValue converts the field value returned by the query into the go value of the field type,
+// as float64, int64, bool or string, by its dynamic type. Values of the fields cast by -float-fields,
+// -integer-fields or -boolean-fields are returned as strings, and parsed. It returns false if the value
+// can not be converted, such as a string not of the number or boolean it is cast to.
+func castValue(vtype string, v interface{}) (interface{}, bool) {
+	switch vtype {
+	case "float":
+		switch v := v.(type) {
+		case json.Number:
+			f, err := v.Float64()
+			return f, err == nil
+		case string:
+			f, err := strconv.ParseFloat(v, 64)
+			return f, err == nil && !math.IsNaN(f) && !math.IsInf(f, 0)
+		}
+	case "integer":
+		switch v := v.(type) {
+		case json.Number:
+			n, err := v.Int64()
+			return n, err == nil
+		case string:
+			n, err := strconv.ParseInt(v, 10, 64)
+			return n, err == nil
+		}
+	case "boolean":
+		switch v := v.(type) {
+		case bool:
+			return v, true
+		case string:
+			b, err := strconv.ParseBool(v)
+			return b, err == nil
+		}
+	case "string":
+		if s, ok := v.(string); ok {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// formatValue formats the field value converted by castValue as it is written in text.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return formatFloat(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return v.(string)
+	}
+}
+
+// formatFloat formats a float like encoding/json, the way InfluxDB returns it.
+func formatFloat(f float64) string {
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	s := strconv.FormatFloat(f, format, -1, 64)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		if n := len(s); n >= 4 && s[n-4] == 'e' && s[n-3] == '-' && s[n-2] == '0' {
+			s = s[:n-2] + s[n-1:]
+		}
+	}
+	return s
+}
+
+func (jw *jsonWriter) Close() error {
+	return jw.f.Close()
+}
+
+func (jw *jsonWriter) Stats() []*FileStat {
+	return jw.f.Stats()
+}
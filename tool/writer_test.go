@@ -0,0 +1,154 @@
+package tool
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/influxdata/influxdb1-client/models"
+)
+
+func TestSeriesColumnsDecode(t *testing.T) {
+	fieldMap := map[string]string{"usage": "float", "num": "float", "ok": "boolean", "note": "string"}
+	sc := newSeriesColumns([]string{"host"}, fieldMap, map[string]string{"host": "host"}, map[string]string{}, []string{"usage"})
+	row := &models.Row{
+		Tags:    map[string]string{"region": "eu", "zone": ""},
+		Columns: []string{"time", "host", "note", "num", "ok", "usage", "usage_1"},
+		Values: [][]interface{}{
+			{json.Number("1"), "h0", "n", "1.5", true, json.Number("0.5"), nil},
+			{json.Number("2"), nil, nil, "bad", "maybe", nil, "str"},
+			{json.Number("3"), "h1", nil, nil, nil, nil, "2.5"},
+		},
+	}
+	points := make([]*seriesPoint, 0)
+	if err := sc.decode(row, func(p *seriesPoint) error {
+		points = append(points, p)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := []*seriesPoint{
+		{
+			time: 1,
+			tags: [][2]string{{"region", "eu"}, {"host", "h0"}},
+			fields: []seriesField{
+				{key: "note", vtype: "string", value: "n"},
+				{key: "num", vtype: "float", value: 1.5},
+				{key: "ok", vtype: "boolean", value: true},
+				{key: "usage", vtype: "float", value: 0.5},
+			},
+		},
+		{
+			// values not converted into the field types are left out
+			time:   2,
+			tags:   [][2]string{{"region", "eu"}},
+			fields: []seriesField{},
+		},
+		{
+			// the strings of a field of mixed types are converted too
+			time:   3,
+			tags:   [][2]string{{"region", "eu"}, {"host", "h1"}},
+			fields: []seriesField{{key: "usage", vtype: "float", value: 2.5}},
+		},
+	}
+	if !reflect.DeepEqual(points, want) {
+		t.Fatalf("decoded points = %+v, want %+v", points, want)
+	}
+	// fields are sorted by key after the time and tags
+	if got := sc.record(points[0]); !reflect.DeepEqual(got, []interface{}{int64(1), "h0", "n", 1.5, true, 0.5}) {
+		t.Errorf("record = %v", got)
+	}
+	if got := sc.record(points[2]); !reflect.DeepEqual(got, []interface{}{int64(3), "h1", nil, nil, nil, 2.5}) {
+		t.Errorf("record = %v", got)
+	}
+
+	// a slice of shards without some fields returns fewer columns, still followed by the companion columns
+	drift := &models.Row{
+		Columns: []string{"time", "host", "num", "usage"},
+		Values:  [][]interface{}{{json.Number("4"), "h2", "3.5", "4.5"}},
+	}
+	points = points[:0]
+	if err := sc.decode(drift, func(p *seriesPoint) error {
+		points = append(points, p)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := sc.record(points[0]); !reflect.DeepEqual(got, []interface{}{int64(4), "h2", nil, 3.5, nil, 4.5}) {
+		t.Errorf("record = %v", got)
+	}
+}
+
+func TestCastValue(t *testing.T) {
+	tests := []struct {
+		vtype string
+		v     interface{}
+		want  interface{}
+		ok    bool
+	}{
+		{vtype: "float", v: json.Number("1.5"), want: 1.5, ok: true},
+		{vtype: "float", v: json.Number("2"), want: 2.0, ok: true},
+		{vtype: "float", v: "-1.5e3", want: -1500.0, ok: true},
+		{vtype: "float", v: "abc", ok: false},
+		{vtype: "float", v: "NaN", ok: false},
+		{vtype: "float", v: "+Inf", ok: false},
+		{vtype: "float", v: true, ok: false},
+		{vtype: "integer", v: json.Number("42"), want: int64(42), ok: true},
+		{vtype: "integer", v: "-7", want: int64(-7), ok: true},
+		{vtype: "integer", v: "1.5", ok: false},
+		{vtype: "integer", v: json.Number("1.5"), ok: false},
+		{vtype: "boolean", v: true, want: true, ok: true},
+		{vtype: "boolean", v: "false", want: false, ok: true},
+		{vtype: "boolean", v: "yes", ok: false},
+		{vtype: "string", v: "abc", want: "abc", ok: true},
+		{vtype: "string", v: json.Number("1"), ok: false},
+		{vtype: "unsigned", v: json.Number("1"), ok: false},
+	}
+	for _, tt := range tests {
+		got, ok := castValue(tt.vtype, tt.v)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("castValue(%s, %#v) = %#v, %v, want %#v, %v", tt.vtype, tt.v, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestJsonValue(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want interface{}
+	}{
+		{v: 1.0, want: json.Number("1.0")},
+		{v: 2.5, want: json.Number("2.5")},
+		{v: 1e21, want: json.Number("1e+21")},
+		{v: int64(3), want: int64(3)},
+		{v: true, want: true},
+		{v: "1", want: "1"},
+	}
+	for _, tt := range tests {
+		if got := jsonValue(tt.v); got != tt.want {
+			t.Errorf("jsonValue(%#v) = %#v, want %#v", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := []struct {
+		f    float64
+		want string
+	}{
+		{f: 0, want: "0"},
+		{f: 1, want: "1"},
+		{f: -0.25, want: "-0.25"},
+		{f: 123456789, want: "123456789"},
+		{f: 1e20, want: "100000000000000000000"},
+		{f: 1e21, want: "1e+21"},
+		{f: 1e-6, want: "0.000001"},
+		{f: 1e-7, want: "1e-7"},
+		{f: -2.5e-10, want: "-2.5e-10"},
+	}
+	for _, tt := range tests {
+		if got := formatFloat(tt.f); got != tt.want {
+			t.Errorf("formatFloat(%v) = %s, want %s", tt.f, got, tt.want)
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package util
 
 import (
+	"errors"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -38,3 +40,35 @@ func String2Array(str string) []string {
 	}
 	return values
 }
+
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"KB", 1 << 10},
+	{"MB", 1 << 20},
+	{"GB", 1 << 30},
+	{"TB", 1 << 40},
+	{"K", 1 << 10},
+	{"M", 1 << 20},
+	{"G", 1 << 30},
+	{"T", 1 << 40},
+	{"B", 1},
+}
+
+// ParseSize parses a size in bytes with an optional unit, such as 512, 100MB or 1G, units are powers of 1024.
+func ParseSize(str string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(str))
+	unit := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, unit = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid size: " + str)
+	}
+	return n * unit, nil
+}
@@ -0,0 +1,44 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		str  string
+		want int64
+		err  bool
+	}{
+		{str: "0", want: 0},
+		{str: "512", want: 512},
+		{str: "512B", want: 512},
+		{str: "1K", want: 1 << 10},
+		{str: "1KB", want: 1 << 10},
+		{str: "100MB", want: 100 << 20},
+		{str: "100mb", want: 100 << 20},
+		{str: " 10 GB ", want: 10 << 30},
+		{str: "1G", want: 1 << 30},
+		{str: "2T", want: 2 << 40},
+		{str: "", err: true},
+		{str: "MB", err: true},
+		{str: "-1MB", err: true},
+		{str: "1.5GB", err: true},
+		{str: "10PB", err: true},
+		{str: "ten", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.str)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseSize(%q) = %d, want error", tt.str, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSize(%q) error: %s", tt.str, err)
+		} else if got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, want %d", tt.str, got, tt.want)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package util
 
+import "strings"
+
 // MatchSimple - finds whether the text matches/satisfies the pattern string.
 // supports only '*' wildcard in the pattern.
 // considers a file system path as a flat name space.
@@ -49,3 +51,23 @@ func deepWildcardMatchRune(str, pattern []rune, simple bool) bool {
 	}
 	return len(str) == 0 && len(pattern) == 0
 }
+
+// HasWildcard reports whether the string contains wildcard '*' or '?'.
+func HasWildcard(s string) bool {
+	return strings.ContainsAny(s, "*?")
+}
+
+// WildcardFilter returns the names matching any of the patterns in the order of names.
+// Patterns without wildcard match only the same name.
+func WildcardFilter(patterns, names []string) []string {
+	matches := make([]string, 0)
+	for _, name := range names {
+		for _, pat := range patterns {
+			if (HasWildcard(pat) && WildcardMatch(pat, name)) || name == pat {
+				matches = append(matches, name)
+				break
+			}
+		}
+	}
+	return matches
+}